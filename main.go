@@ -29,6 +29,10 @@ import (
 //go:embed all:zyuc-mock-clean-web/out
 var embeddedFiles embed.FS
 
+// uiPrefix 是 Web 界面的保留路径前缀（需与 next.config.js 中的 basePath 一致）。
+// 该前缀之外的所有请求，无论方法，都交给 mock 处理。
+const uiPrefix = "/_ui"
+
 func getOutboundIP() (string, error) {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
@@ -58,11 +62,27 @@ func generatePrivateKey() (string, error) {
 func main() {
 	listenAddr := flag.String("listen", ":8080", "Listen address (e.g., :8080)")
 	sshListenAddr := flag.String("ssh-listen", "", "SSH listen address (e.g., :2222). If not provided, SSH server will not start.")
+	sshAuth := flag.String("ssh-auth", ssh.AuthAcceptAll, "SSH authentication mode: accept-all lets any client in, users only admits the users managed under /api/ssh/users.")
 	useHTTPS := flag.Bool("https", false, "Enable HTTPS")
 	certFile := flag.String("certfile", "cert.pem", "Path to SSL/TLS certificate file")
 	keyFile := flag.String("keyfile", "key.pem", "Path to SSL/TLS key file")
+	holdTimeout := flag.Duration("hold-timeout", 0, "How long requests wait for an operator reply when a UI is connected (e.g., 30s). A negative value waits indefinitely. Can be overridden per project and per config.")
+	historyMaxAge := flag.Duration("history-max-age", 0, "Delete history older than this (e.g., 720h). 0 keeps history forever. Can be overridden per project.")
+	historyMaxRows := flag.Int("history-max-rows", 0, "Maximum number of rows kept in each history table. 0 means unlimited.")
+	pruneInterval := flag.Duration("history-prune-interval", 10*time.Minute, "How often the retention policies are applied.")
+	vacuumInterval := flag.Duration("history-vacuum-interval", 0, "How often the database file is compacted with VACUUM after pruning (e.g., 24h). 0 disables it.")
 	flag.Parse()
 
+	if *pruneInterval <= 0 {
+		log.Fatalf("Invalid -history-prune-interval %v: must be greater than 0", *pruneInterval)
+	}
+	if *historyMaxAge < 0 {
+		log.Fatalf("Invalid -history-max-age %v: must be 0 (keep forever) or positive", *historyMaxAge)
+	}
+	if *historyMaxRows < 0 {
+		log.Fatalf("Invalid -history-max-rows %d: must be 0 (unlimited) or positive", *historyMaxRows)
+	}
+
 	protocol := "http"
 	if *useHTTPS {
 		protocol = "https"
@@ -116,6 +136,39 @@ func main() {
 		}
 	}()
 
+	// 只有主节点清理历史记录，避免多个实例同时删除与整理同一个数据库文件
+	retention := storage.RetentionPolicy{MaxAge: *historyMaxAge, MaxRows: *historyMaxRows}
+	go func() {
+		ticker := time.NewTicker(*pruneInterval)
+		defer ticker.Stop()
+		lastVacuum := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+				primary, err := db.GetPrimaryServiceInstance(10 * time.Second)
+				if err != nil || primary == nil || primary.Address != regAddr {
+					continue
+				}
+				result, err := db.PruneHistory(retention)
+				if err != nil {
+					log.Printf("History pruning failed: %v", err)
+					continue
+				}
+				if result.Events > 0 || result.SshEvents > 0 {
+					log.Printf("Pruned %d HTTP and %d SSH history records.", result.Events, result.SshEvents)
+				}
+				if *vacuumInterval > 0 && time.Since(lastVacuum) >= *vacuumInterval {
+					if err := db.Vacuum(); err != nil {
+						log.Printf("VACUUM failed: %v", err)
+					}
+					lastVacuum = time.Now()
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
@@ -130,7 +183,7 @@ func main() {
 		os.Exit(0)
 	}()
 
-	b := broker.New(db, regAddr, *useHTTPS)
+	b := broker.New(db, regAddr, *useHTTPS, *holdTimeout, retention)
 
 	// --- Conditionally Start SSH Server ---
 	if *sshListenAddr != "" {
@@ -152,7 +205,7 @@ func main() {
 			log.Fatalf("Failed to read SSH private key: %v", err)
 		}
 
-		sshServer, err := ssh.NewSSHServer(b.GetBus(), db, string(privateKey))
+		sshServer, err := ssh.NewSSHServer(b.GetBus(), b.GetPending(), db, string(privateKey), *holdTimeout, *sshAuth)
 		if err != nil {
 			log.Fatalf("Failed to create SSH server: %v", err)
 		}
@@ -161,12 +214,19 @@ func main() {
 		log.Println("SSH server is not configured to start. Use the -ssh-listen flag to enable it.")
 	}
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Logger(), gin.CustomRecovery(func(c *gin.Context, err any) {
+		// http.ErrAbortHandler 交给 net/http 中断连接，如导出中途失败时不能让客户端以为已经完整收到
+		if err == http.ErrAbortHandler {
+			panic(err)
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
 
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept"}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "X-Operator"}
 	router.Use(cors.New(config))
 
 	api := router.Group("/api")
@@ -180,44 +240,70 @@ func main() {
 		api.POST("/rules", b.HandleAddRule)
 		api.PUT("/rules/:ruleID", b.HandleUpdateRule)
 		api.DELETE("/rules/:ruleID", b.HandleDeleteRule)
+		api.GET("/upstreams", b.HandleGetUpstreams)
+		api.POST("/upstream", b.HandleSetUpstream)
+		api.DELETE("/upstream/:id", b.HandleDeleteUpstream)
 
 		// SSH Mock routes
 		api.POST("/ssh/config", b.HandleSetSshConfig)
 		api.GET("/ssh/configs", b.HandleGetSshConfigs)
-		api.GET("/ssh/config/:command", b.HandleGetSshConfig)
-		api.DELETE("/ssh/config/:command", b.HandleDeleteSshConfig)
+		api.GET("/ssh/config/*command", b.HandleGetSshConfig)
+		api.DELETE("/ssh/config/*command", b.HandleDeleteSshConfig)
+		api.GET("/ssh/users", b.HandleGetSshUsers)
+		api.POST("/ssh/user", b.HandleSetSshUser)
+		api.DELETE("/ssh/user/:username", b.HandleDeleteSshUser)
+		api.GET("/ssh/profiles", b.HandleGetSshProfiles)
+		api.POST("/ssh/profile", b.HandleSetSshProfile)
+		api.DELETE("/ssh/profile/:name", b.HandleDeleteSshProfile)
 		api.GET("/ssh/history", b.HandleGetSshHistory)
+		api.GET("/ssh/history/export", b.HandleExportSshHistory)
+		api.DELETE("/ssh/history", b.HandlePurgeSshHistory)
 
 		// Common routes
 		api.GET("/events", b.HandleSSEConnection)
 		api.POST("/events/forward", b.HandleForwardedEvent)
 		api.GET("/services", b.HandleGetServices)
 		api.POST("/respond", b.HandleRespond)
+		api.GET("/pending", b.HandleGetPending)
+		api.POST("/pending/release", b.HandleReleaseAllPending)
+		api.POST("/pending/:requestId/claim", b.HandleClaimPending)
+		api.POST("/pending/:requestId/unclaim", b.HandleUnclaimPending)
+		api.POST("/pending/:requestId/release", b.HandleReleasePending)
+		api.POST("/pending/:requestId/reject", b.HandleRejectPending)
+		api.POST("/pending/:requestId/extend", b.HandleExtendPending)
 		api.GET("/history", b.HandleGetHistory)
+		api.GET("/history/export", b.HandleExportHistory)
+		api.DELETE("/history", b.HandlePurgeHistory)
 		api.GET("/history/sources", b.HandleGetHistorySources)
+		api.POST("/history/promote", b.HandlePromoteEvents)
+		api.POST("/history/:requestId/replay", b.HandleReplayEvent)
+		api.GET("/stats", b.HandleGetStats)
+		api.GET("/unmatched", b.HandleGetUnmatched)
+		api.POST("/unmatched/:requestId/config", b.HandleCreateConfigFromUnmatched)
+		api.GET("/projects", b.HandleGetProjectSettings)
+		api.POST("/project", b.HandleSetProjectSetting)
 	}
 
-	router.GET("/app.config.js", func(c *gin.Context) {
-		jsContent := fmt.Sprintf(`window.APP_CONFIG = { apiBaseUrl: "%s://%s" };`, protocol, regAddr)
-		c.Data(http.StatusOK, "application/javascript", []byte(jsContent))
+	fsys, err := fs.Sub(embeddedFiles, "zyuc-mock-clean-web/out")
+	if err != nil {
+		log.Fatalf("Failed to get sub filesystem: %v", err)
+	}
+	uiServer := http.StripPrefix(uiPrefix, http.FileServer(http.FS(fsys)))
+	router.GET(uiPrefix, func(c *gin.Context) {
+		c.Redirect(http.StatusMovedPermanently, uiPrefix+"/")
 	})
-
-	router.NoRoute(func(c *gin.Context) {
-		if c.Request.Method != "POST" && c.Request.Method != "PUT" && c.Request.Method != "PATCH" &&
-			!strings.HasPrefix(c.Request.URL.Path, "/api/") {
-
-			fsys, err := fs.Sub(embeddedFiles, "zyuc-mock-clean-web/out")
-			if err != nil {
-				log.Printf("Failed to get sub filesystem: %v", err)
-				c.String(http.StatusInternalServerError, "Internal Server Error")
-				return
-			}
-			http.FileServer(http.FS(fsys)).ServeHTTP(c.Writer, c.Request)
+	router.GET(uiPrefix+"/*filepath", func(c *gin.Context) {
+		if c.Param("filepath") == "/app.config.js" {
+			jsContent := fmt.Sprintf(`window.APP_CONFIG = { apiBaseUrl: "%s://%s" };`, protocol, regAddr)
+			c.Data(http.StatusOK, "application/javascript", []byte(jsContent))
 			return
 		}
-		b.HandlePublish(c)
+		uiServer.ServeHTTP(c.Writer, c.Request)
 	})
 
+	// 除 /api 与界面前缀外，所有方法的请求都作为 mock 请求处理
+	router.NoRoute(b.HandlePublish)
+
 	if *useHTTPS {
 		log.Printf("Gin server starting with HTTPS, listening on %s", *listenAddr)
 		if err := router.RunTLS(*listenAddr, *certFile, *keyFile); err != nil {
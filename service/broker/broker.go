@@ -3,45 +3,55 @@ package broker
 import (
 	"bytes"
 	"crypto/tls"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"mock.com/zyuc-mock-clean/service/bus"
+	"mock.com/zyuc-mock-clean/service/pending"
 	"mock.com/zyuc-mock-clean/storage"
 )
 
-type PendingRequest struct {
-	ResponseChan    chan string
-	Endpoint        string
-	DefaultResponse string
-}
+// maxPageSize 是历史记录单页最多返回的条数。
+const maxPageSize = 1000
 
 type EventBroker struct {
-	bus           *bus.JsonEventBus
-	db            *storage.DB
-	pendingReqs   map[string]*PendingRequest
-	pendingReqsMu sync.Mutex
-	serverAddr    string
-	httpClient    *http.Client
+	bus         *bus.JsonEventBus
+	db          *storage.DB
+	pending     *pending.Registry // HTTP 请求与 SSH 命令共用的挂起请求表
+	serverAddr  string
+	httpClient  *http.Client
+	holdTimeout time.Duration // 全局挂起时长，负值表示一直等待操作员响应
+	retention   storage.RetentionPolicy
 }
 
-func New(db *storage.DB, serverAddr string, useHTTPS bool) *EventBroker {
+func New(db *storage.DB, serverAddr string, useHTTPS bool, holdTimeout time.Duration, retention storage.RetentionPolicy) *EventBroker {
+	eventBus := bus.New()
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 	return &EventBroker{
-		bus:         bus.New(),
+		bus:         eventBus,
 		db:          db,
-		pendingReqs: make(map[string]*PendingRequest),
+		pending:     pending.New(eventBus),
 		serverAddr:  serverAddr,
-		httpClient:  &http.Client{Timeout: 15 * time.Second, Transport: tr}, // 增加超时以适应等待
+		holdTimeout: holdTimeout,
+		retention:   retention,
+		httpClient: &http.Client{
+			Timeout:   15 * time.Second, // 增加超时以适应等待
+			Transport: tr,
+			// mock 可能返回 3xx，转发时必须原样交给调用方而不是跟随跳转
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
 	}
 }
 
@@ -50,6 +60,11 @@ func (b *EventBroker) GetBus() *bus.JsonEventBus {
 	return b.bus
 }
 
+// GetPending returns the pending request registry shared with the SSH server.
+func (b *EventBroker) GetPending() *pending.Registry {
+	return b.pending
+}
+
 func (b *EventBroker) isPrimary() (bool, *storage.ServiceInstance) {
 	primary, err := b.db.GetPrimaryServiceInstance(10 * time.Second)
 	if err != nil {
@@ -64,20 +79,49 @@ func (b *EventBroker) isPrimary() (bool, *storage.ServiceInstance) {
 
 func (b *EventBroker) HandleSetSshConfig(c *gin.Context) {
 	var req struct {
-		Command  string `json:"command"`
-		Project  string `json:"project"`
-		Remark   string `json:"remark"`
-		Response string `json:"response"`
+		Command     string `json:"command"`
+		Mode        string `json:"mode"`
+		Priority    int    `json:"priority"`
+		Project     string `json:"project"`
+		Remark      string `json:"remark"`
+		Response    string `json:"response"`
+		Stderr      string `json:"stderr"`
+		ExitCode    int    `json:"exitCode"`
+		HoldTimeout *int   `json:"holdTimeout"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
 		return
 	}
-	if req.Command == "" {
+	if strings.TrimSpace(req.Command) == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Command cannot be empty"})
 		return
 	}
-	if err := b.db.SetSshConfig(req.Command, req.Project, req.Remark, req.Response); err != nil {
+	pattern, err := storage.ParseSshCommandPattern(req.Command)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid command: " + err.Error()})
+		return
+	}
+	if req.HoldTimeout != nil && *req.HoldTimeout < storage.HoldIndefinitely {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "holdTimeout must be >= 0, or -1 to wait indefinitely"})
+		return
+	}
+	if err := validateExitCode(req.ExitCode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	config := &storage.SshConfig{
+		Command:     pattern.Raw,
+		Mode:        req.Mode,
+		Priority:    req.Priority,
+		Project:     req.Project,
+		Remark:      req.Remark,
+		Response:    req.Response,
+		Stderr:      req.Stderr,
+		ExitCode:    req.ExitCode,
+		HoldTimeout: req.HoldTimeout,
+	}
+	if err := b.db.SetSshConfig(config); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save SSH configuration"})
 		return
 	}
@@ -94,9 +138,20 @@ func (b *EventBroker) HandleGetSshConfigs(c *gin.Context) {
 	c.JSON(http.StatusOK, configs)
 }
 
+// sshConfigKeyFromRequest 从 /ssh/config/*command 路由中取出配置的命令，含有 ? 等特殊字符的命令也可以通过 command 查询参数传入；
+// 模式通过 mode 查询参数指定，缺省为空（任意模式）。
+func sshConfigKeyFromRequest(c *gin.Context) (command, mode string) {
+	command = strings.TrimPrefix(c.Param("command"), "/")
+	if q, ok := c.GetQuery("command"); ok {
+		command = q
+	}
+	return command, c.Query("mode")
+}
+
+// HandleGetSshConfig 按保存时的命令获取 SSH 配置，参数、通配与正则命令按原文查找而不做匹配。
 func (b *EventBroker) HandleGetSshConfig(c *gin.Context) {
-	command := c.Param("command")
-	config, err := b.db.GetSshConfigForCommand(command)
+	command, mode := sshConfigKeyFromRequest(c)
+	config, err := b.db.GetSshConfig(command, mode)
 	if err != nil {
 		log.Printf("broker: Failed to get SSH config for command %s: %v", command, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve SSH configuration"})
@@ -110,8 +165,8 @@ func (b *EventBroker) HandleGetSshConfig(c *gin.Context) {
 }
 
 func (b *EventBroker) HandleDeleteSshConfig(c *gin.Context) {
-	command := c.Param("command")
-	if err := b.db.DeleteSshConfig(command); err != nil {
+	command, mode := sshConfigKeyFromRequest(c)
+	if err := b.db.DeleteSshConfig(command, mode); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete SSH configuration"})
 		return
 	}
@@ -141,7 +196,7 @@ func (b *EventBroker) HandlePublish(c *gin.Context) {
 	}
 
 	// 构建转发请求
-	url := primaryNode.Protocol + "://" + primaryNode.Address + c.Request.URL.Path
+	url := primaryNode.Protocol + "://" + primaryNode.Address + c.Request.URL.RequestURI()
 	proxyReq, err := http.NewRequest(c.Request.Method, url, bytes.NewReader(bodyBytes))
 	if err != nil {
 		log.Printf("Failed to create proxy request: %v", err)
@@ -149,9 +204,10 @@ func (b *EventBroker) HandlePublish(c *gin.Context) {
 		return
 	}
 
-	// 复制头信息，并添加源地址
+	// 复制头信息，并添加源地址与调用方地址
 	proxyReq.Header = c.Request.Header
 	proxyReq.Header.Set("X-Forwarded-For-Service", b.serverAddr)
+	proxyReq.Header.Set("X-Forwarded-For", c.ClientIP())
 
 	// 发送请求并等待主节点响应
 	resp, err := b.httpClient.Do(proxyReq)
@@ -162,13 +218,23 @@ func (b *EventBroker) HandlePublish(c *gin.Context) {
 	}
 	defer resp.Body.Close()
 
-	// 将主节点的响应写回给原始客户端
+	// 将主节点的响应（包括自定义响应头）写回给原始客户端
 	respBody, _ := io.ReadAll(resp.Body)
+	for k, vs := range resp.Header {
+		switch k {
+		case "Content-Length", "Content-Type", "Connection", "Transfer-Encoding":
+			continue
+		}
+		for _, v := range vs {
+			c.Writer.Header().Add(k, v)
+		}
+	}
 	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
 }
 
 // handleCentralPublish - 这是现在只在主节点上运行的核心逻辑
 func (b *EventBroker) handleCentralPublish(c *gin.Context) {
+	start := time.Now()
 	// 确定请求源地址。如果是被转发的，则使用头信息；否则使用当前服务地址。
 	source := c.GetHeader("X-Forwarded-For-Service")
 	if source == "" {
@@ -183,68 +249,124 @@ func (b *EventBroker) handleCentralPublish(c *gin.Context) {
 	bodyString := string(bodyData)
 	endpoint := c.Request.URL.Path
 
-	config, _ := b.db.GetConfigForRequest(endpoint, source)
-	responseToSend := `{"code": 200, "message": "Global default mock response."}`
+	method := c.Request.Method
+	config, pathParams, err := b.db.GetConfigForRequest(method, endpoint, source)
+	if err != nil {
+		log.Printf("broker: Failed to look up config for %s %s: %v", method, endpoint, err)
+	}
+	reqID := uuid.New().String()
+	event := &storage.Event{
+		RequestID:      reqID,
+		Endpoint:       endpoint,
+		Payload:        bodyString,
+		Source:         source,
+		Method:         method,
+		Query:          c.Request.URL.RawQuery,
+		RequestHeaders: recordedHeaders(c.Request.Header),
+		ClientIP:       c.ClientIP(),
+		ReplayOf:       replayOf(c),
+	}
+	// 重放的请求需要知道本次生成的历史记录，以便记录响应差异
+	if event.ReplayOf != "" {
+		c.Set(replayEventKey, reqID)
+	}
+
+	// 未命中任何配置时，若该设备或路径配置了上游服务，则转发到真实服务并录制响应
+	if config == nil {
+		upstream, err := b.db.GetUpstreamForRequest(endpoint, source)
+		if err != nil {
+			log.Printf("broker: Failed to look up upstream for %s: %v", endpoint, err)
+		}
+		if upstream != nil {
+			event.Project = upstream.Project
+			b.proxyToUpstream(c, upstream, event, start)
+			return
+		}
+	}
+
+	rc := newRequestContext(c, bodyString, pathParams)
+	responseToSend := newMockResponse(`{"code": 200, "message": "Global default mock response."}`, storage.ResponseSpec{})
+	var ruleID uint
+	// 模板渲染失败时返回未渲染的原文，并在历史记录的状态中注明错误
+	statusSuffix := ""
 	if config != nil {
-		responseToSend = config.DefaultResponse
-		// ... rule matching logic ...
+		responseToSend = newMockResponse(config.DefaultResponse, config.ResponseSpec)
+		if rule := matchRule(config.Rules, rc); rule != nil {
+			responseToSend = newMockResponse(rule.Response, rule.ResponseSpec)
+			ruleID = rule.ID
+		}
+		if rendered, err := renderResponse(responseToSend.Body, reqID, rc); err != nil {
+			log.Printf("broker: Failed to render response template for %s %s: %v", method, endpoint, err)
+			statusSuffix = " (Template Error: " + err.Error() + ")"
+		} else {
+			responseToSend.Body = rendered
+		}
 	}
 
-	reqID := uuid.New().String()
 	project := ""
 	if config != nil {
 		project = config.Project
 	}
+	event.Project = project
+	event.Unmatched = config == nil
+	event.RuleID = ruleID
+	if config != nil {
+		event.ConfigID = config.ID
+	}
 
-	// **关键决策点**: 主节点检查真实的UI客户端连接数
-	if b.bus.InteractiveSubscriberCount() == 0 {
+	// **关键决策点**: 主节点检查真实的UI客户端连接数；重放的请求不等待操作员
+	if b.bus.InteractiveSubscriberCount() == 0 || event.ReplayOf != "" {
 		log.Printf("broker [primary]: No UI clients. Responding immediately for request from %s.", source)
-		b.db.CreateEvent(reqID, endpoint, project, bodyString, responseToSend, "Auto-Responded", source)
-		c.Data(http.StatusOK, "application/xml; charset=utf-8", []byte(responseToSend))
+		event.ResponseBody = responseToSend.Body
+		event.Status = "Auto-Responded" + statusSuffix
+		responseToSend.write(c)
+		recordResponse(c, event, start)
+		b.db.CreateEvent(event)
 		return
 	}
 
-	// 如果有UI客户端，则进入0秒等待流程
-	log.Printf("broker [primary]: UI client detected. Delaying response for request from %s.", source)
-	if err := b.db.CreateEvent(reqID, endpoint, project, bodyString, "", "Pending", source); err != nil {
-		log.Printf("broker: Failed to save pending event: %v", err)
+	// 如果有UI客户端，则按配置、工程、全局的顺序确定挂起时长并等待操作员响应
+	var holdOverride *int
+	if config != nil {
+		holdOverride = config.HoldTimeout
 	}
-
-	pr := &PendingRequest{
-		ResponseChan:    make(chan string),
-		DefaultResponse: responseToSend,
+	hold := b.db.ResolveHoldTimeout(holdOverride, project, b.holdTimeout)
+	log.Printf("broker [primary]: UI client detected. Holding request from %s for %v.", source, hold)
+	event.Status = "Pending"
+	if err := b.db.CreateEvent(event); err != nil {
+		log.Printf("broker: Failed to save pending event: %v", err)
 	}
 
-	// 注意：PendingRequest现在只存在于主节点的内存中
-	b.pendingReqsMu.Lock()
-	b.pendingReqs[reqID] = pr
-	b.pendingReqsMu.Unlock()
-
-	defer func() {
-		b.pendingReqsMu.Lock()
-		delete(b.pendingReqs, reqID)
-		b.pendingReqsMu.Unlock()
-	}()
-
-	ssePayload := map[string]string{
-		"requestId": reqID, "payload": bodyString, "endpoint": endpoint,
-		"defaultResponse": responseToSend, "project": project, "source": source, "type": "http",
+	// 挂起的请求只存在于主节点的内存中；登记时通过 SSE 推送给监控页面，超时后自动投递默认响应
+	pr := &pending.Request{
+		ID:              reqID,
+		Type:            pending.TypeHTTP,
+		Method:          method,
+		Endpoint:        endpoint,
+		PathParams:      pathParams,
+		Payload:         bodyString,
+		Project:         project,
+		Source:          source,
+		DefaultResponse: responseToSend.pending(),
+		TimeoutStatus:   "Auto-Responded" + statusSuffix,
 	}
-	ssePayloadJSON, _ := json.Marshal(ssePayload)
-	b.bus.Publish(string(ssePayloadJSON))
+	b.pending.Add(pr, hold)
+	defer b.pending.Remove(reqID)
 
 	select {
-	case responseBody := <-pr.ResponseChan:
-		log.Printf("broker [primary]: Responding to request %s with user response.", reqID)
-		b.db.UpdateEventResponse(reqID, responseBody, "Responded (Custom)")
-		c.Data(http.StatusOK, "application/xml; charset=utf-8", []byte(responseBody))
-	case <-time.After(0 * time.Second):
-		log.Printf("broker [primary]: Request %s timed out after 0 seconds.", reqID)
-		b.db.UpdateEventResponse(reqID, responseToSend, "Auto-Responded")
-		c.Data(http.StatusOK, "application/xml; charset=utf-8", []byte(responseToSend))
+	case reply := <-pr.Replies():
+		log.Printf("broker [primary]: Responding to request %s: %s.", reqID, reply.Status)
+		mockResponseFrom(reply.Response).write(c)
+		event.ResponseBody = reply.Response.Body
+		event.Status = reply.Status
+		recordResponse(c, event, start)
 	case <-c.Request.Context().Done():
 		log.Printf("broker [primary]: Caller for request %s disconnected.", reqID)
-		b.db.UpdateEventResponse(reqID, "", "Cancelled")
+		event.Status = "Cancelled"
+		event.LatencyMs = time.Since(start).Milliseconds()
+	}
+	if err := b.db.UpdateEventResponse(event); err != nil {
+		log.Printf("broker: Failed to update event %s: %v", reqID, err)
 	}
 }
 
@@ -257,27 +379,58 @@ func (b *EventBroker) HandleRespond(c *gin.Context) {
 	}
 
 	var req struct {
-		RequestID    string `json:"requestId"`
-		ResponseBody string `json:"responseBody"`
-		Source       string `json:"source,omitempty"`
+		RequestID    string            `json:"requestId"`
+		ResponseBody string            `json:"responseBody"`
+		StatusCode   int               `json:"statusCode,omitempty"`
+		ContentType  string            `json:"contentType,omitempty"`
+		Headers      map[string]string `json:"headers,omitempty"`
+		Stderr       string            `json:"stderr,omitempty"`
+		ExitCode     int               `json:"exitCode,omitempty"`
+		Source       string            `json:"source,omitempty"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
 		return
 	}
+	if err := validateResponseSpec(storage.ResponseSpec{StatusCode: req.StatusCode, Headers: req.Headers}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid response: " + err.Error()})
+		return
+	}
+	if err := validateExitCode(req.ExitCode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	b.pendingReqsMu.Lock()
-	pendingReq, ok := b.pendingReqs[req.RequestID]
-	b.pendingReqsMu.Unlock()
-
+	pendingReq, ok := b.pending.Get(req.RequestID)
 	if !ok {
 		log.Printf("broker [primary]: Request ID %s not found in pending requests.", req.RequestID)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Request ID not found or already processed"})
 		return
 	}
 
-	// 主节点直接通过通道唤醒正在等待的 handleCentralPublish 协程
-	pendingReq.ResponseChan <- req.ResponseBody
+	// 未指定的状态码、内容类型和响应头沿用默认响应
+	response := pendingReq.DefaultResponse
+	response.Body = req.ResponseBody
+	if req.StatusCode != 0 {
+		response.StatusCode = req.StatusCode
+	}
+	if req.ContentType != "" {
+		response.ContentType = req.ContentType
+	}
+	if req.Headers != nil {
+		response.Headers = req.Headers
+	}
+	// SSH 命令的输出整体由操作员给出，不沿用默认的错误输出与退出码
+	if pendingReq.Type == pending.TypeSSH {
+		response.Stderr = req.Stderr
+		response.ExitCode = req.ExitCode
+	}
+
+	// 主节点直接唤醒正在等待的 HTTP 请求或 SSH 命令
+	if err := b.pending.Respond(req.RequestID, operatorFromRequest(c), pending.Reply{Response: response, Status: "Responded (Custom)"}); err != nil {
+		writePendingError(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"status": "Response processed by primary."})
 }
 
@@ -297,16 +450,40 @@ func (b *EventBroker) HandleForwardedEvent(c *gin.Context) {
 }
 func (b *EventBroker) HandleAddRule(c *gin.Context) {
 	var req struct {
-		ConfigID uint   `json:"configID"`
-		Keyword  string `json:"keyword"`
-		Response string `json:"response"`
+		ConfigID    uint              `json:"configID"`
+		Priority    int               `json:"priority"`
+		Keyword     string            `json:"keyword"`
+		Matcher     *storage.Matcher  `json:"matcher"`
+		Response    string            `json:"response"`
+		StatusCode  int               `json:"statusCode"`
+		ContentType string            `json:"contentType"`
+		Headers     map[string]string `json:"headers"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Keyword == "" && req.Matcher == nil) || req.ConfigID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: configID and keyword or matcher cannot be empty"})
+		return
+	}
+	if req.Matcher != nil {
+		if err := validateMatcher(req.Matcher); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid matcher: " + err.Error()})
+			return
+		}
 	}
-	if err := c.ShouldBindJSON(&req); err != nil || req.Keyword == "" || req.ConfigID == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: configID and keyword cannot be empty"})
+
+	spec := storage.ResponseSpec{StatusCode: req.StatusCode, ContentType: req.ContentType, Headers: req.Headers}
+	if err := validateResponseSpec(spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid response: " + err.Error()})
 		return
 	}
 
-	rule, err := b.db.AddRuleToConfig(req.ConfigID, req.Keyword, req.Response)
+	rule, err := b.db.AddRuleToConfig(storage.ResponseRule{
+		ConfigID:     req.ConfigID,
+		Priority:     req.Priority,
+		Keyword:      req.Keyword,
+		Matcher:      req.Matcher,
+		Response:     req.Response,
+		ResponseSpec: spec,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add rule"})
 		return
@@ -322,15 +499,37 @@ func (b *EventBroker) HandleUpdateRule(c *gin.Context) {
 	}
 
 	var req struct {
-		Keyword  string `json:"keyword"`
-		Response string `json:"response"`
+		Priority    *int              `json:"priority"`
+		Keyword     string            `json:"keyword"`
+		Matcher     *storage.Matcher  `json:"matcher"`
+		Response    string            `json:"response"`
+		StatusCode  int               `json:"statusCode"`
+		ContentType string            `json:"contentType"`
+		Headers     map[string]string `json:"headers"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Keyword == "" && req.Matcher == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: keyword or matcher cannot be empty"})
+		return
+	}
+	if req.Matcher != nil {
+		if err := validateMatcher(req.Matcher); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid matcher: " + err.Error()})
+			return
+		}
 	}
-	if err := c.ShouldBindJSON(&req); err != nil || req.Keyword == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: keyword cannot be empty"})
+
+	spec := storage.ResponseSpec{StatusCode: req.StatusCode, ContentType: req.ContentType, Headers: req.Headers}
+	if err := validateResponseSpec(spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid response: " + err.Error()})
 		return
 	}
 
-	rule, err := b.db.UpdateRule(uint(ruleID), req.Keyword, req.Response)
+	rule, err := b.db.UpdateRule(uint(ruleID), req.Priority, storage.ResponseRule{
+		Keyword:      req.Keyword,
+		Matcher:      req.Matcher,
+		Response:     req.Response,
+		ResponseSpec: spec,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
 		return
@@ -352,11 +551,25 @@ func (b *EventBroker) HandleDeleteRule(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "Rule deleted successfully"})
 }
 
+// configKeyFromRequest 从 /config/*endpoint 路由中取出配置的键。method 与 source 通过查询参数指定，缺省为空；
+// 含有 ? 等特殊字符的模板或正则路径也可以通过 endpoint 查询参数传入。
+func configKeyFromRequest(c *gin.Context) (method, endpoint, source string) {
+	endpoint = c.Param("endpoint")
+	if q, ok := c.GetQuery("endpoint"); ok {
+		endpoint = q
+	} else if strings.HasPrefix(endpoint, "/~") {
+		// 正则路径以 ~ 开头，在路由中表现为 /config/~...
+		endpoint = endpoint[1:]
+	}
+	return strings.ToUpper(c.Query("method")), endpoint, c.Query("source")
+}
+
+// HandleGetConfig 按 (method, endpoint, source) 获取配置。
 func (b *EventBroker) HandleGetConfig(c *gin.Context) {
-	endpoint := c.Param("endpoint")
-	config, err := b.db.GetConfigByEndpoint(endpoint)
+	method, endpoint, source := configKeyFromRequest(c)
+	config, err := b.db.GetConfig(method, endpoint, source)
 	if err != nil {
-		log.Printf("broker: Failed to get config for endpoint %s: %v", endpoint, err)
+		log.Printf("broker: Failed to get config for %s %s (%s): %v", method, endpoint, source, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve configuration"})
 		return
 	}
@@ -381,11 +594,16 @@ func (b *EventBroker) HandleGetConfigSources(c *gin.Context) {
 
 func (b *EventBroker) HandleSetConfig(c *gin.Context) {
 	var req struct {
-		Endpoint        string `json:"endpoint"`
-		Project         string `json:"project"`
-		Remark          string `json:"remark"`
-		DefaultResponse string `json:"defaultResponse"`
-		Source          string `json:"source"`
+		Method          string            `json:"method"`
+		Endpoint        string            `json:"endpoint"`
+		Project         string            `json:"project"`
+		Remark          string            `json:"remark"`
+		DefaultResponse string            `json:"defaultResponse"`
+		Source          string            `json:"source"`
+		StatusCode      int               `json:"statusCode"`
+		ContentType     string            `json:"contentType"`
+		Headers         map[string]string `json:"headers"`
+		HoldTimeout     *int              `json:"holdTimeout"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
@@ -395,7 +613,30 @@ func (b *EventBroker) HandleSetConfig(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Endpoint cannot be empty"})
 		return
 	}
-	if err := b.db.SetConfig(req.Endpoint, req.Project, req.Remark, req.DefaultResponse, req.Source); err != nil {
+	if _, err := storage.ParseEndpointPattern(req.Endpoint); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid endpoint: " + err.Error()})
+		return
+	}
+	spec := storage.ResponseSpec{StatusCode: req.StatusCode, ContentType: req.ContentType, Headers: req.Headers}
+	if err := validateResponseSpec(spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid response: " + err.Error()})
+		return
+	}
+	if req.HoldTimeout != nil && *req.HoldTimeout < storage.HoldIndefinitely {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "holdTimeout must be >= 0, or -1 to wait indefinitely"})
+		return
+	}
+	config := &storage.Config{
+		Method:          strings.ToUpper(req.Method),
+		Endpoint:        req.Endpoint,
+		Project:         req.Project,
+		Remark:          req.Remark,
+		DefaultResponse: req.DefaultResponse,
+		Source:          req.Source,
+		HoldTimeout:     req.HoldTimeout,
+		ResponseSpec:    spec,
+	}
+	if err := b.db.SetConfig(config); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
 		return
 	}
@@ -413,44 +654,191 @@ func (b *EventBroker) HandleGetConfigs(c *gin.Context) {
 }
 
 func (b *EventBroker) HandleGetHistory(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
-	project := c.Query("project")
-	search := c.Query("search")
-	source := c.Query("source")
+	page, err := pageRequestFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := b.eventFilterFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	events, total, err := b.db.GetEvents(page, pageSize, project, search, source)
+	events, result, err := b.db.GetEvents(filter, page)
+	if errors.Is(err, storage.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve history"})
 		return
 	}
+	c.JSON(http.StatusOK, pageResponse(events, page, result))
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"data":     events,
-		"total":    total,
-		"page":     page,
-		"pageSize": pageSize,
-	})
+// pageRequestFromRequest 从查询参数中读取排序与分页方式。cursor 不为空时按游标分页并忽略 page；
+// 默认只在按页码分页时统计总数，可用 total=true 或 total=false 指定。
+func pageRequestFromRequest(c *gin.Context) (storage.PageRequest, error) {
+	page := storage.PageRequest{
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+	}
+	var err error
+	if page.Page, err = strconv.Atoi(c.DefaultQuery("page", "1")); err != nil || page.Page < 1 {
+		return page, fmt.Errorf("invalid page: %s", c.Query("page"))
+	}
+	if page.PageSize, err = strconv.Atoi(c.DefaultQuery("pageSize", "20")); err != nil || page.PageSize < 1 || page.PageSize > maxPageSize {
+		return page, fmt.Errorf("pageSize must be between 1 and %d", maxPageSize)
+	}
+	page.WithTotal = page.Cursor == ""
+	if v := c.Query("total"); v != "" {
+		if page.WithTotal, err = strconv.ParseBool(v); err != nil {
+			return page, fmt.Errorf("invalid total: %s", v)
+		}
+	}
+	return page, nil
+}
+
+// pageResponse 组装分页查询的响应：page 只在按页码分页时返回，total 只在统计了总数时返回。
+func pageResponse(data interface{}, page storage.PageRequest, result storage.PageResult) gin.H {
+	resp := gin.H{
+		"data":       data,
+		"pageSize":   page.PageSize,
+		"nextCursor": result.NextCursor,
+	}
+	if page.Cursor == "" {
+		resp["page"] = page.Page
+	}
+	if result.Total != nil {
+		resp["total"] = *result.Total
+	}
+	return resp
+}
+
+// eventFilterFromRequest 从查询参数中读取历史记录的过滤条件，并检查全文检索表达式的语法。
+func (b *EventBroker) eventFilterFromRequest(c *gin.Context) (storage.EventFilter, error) {
+	filter := storage.EventFilter{
+		Project:  c.Query("project"),
+		Source:   c.Query("source"),
+		Search:   c.Query("search"),
+		Query:    c.Query("q"),
+		Endpoint: c.Query("endpoint"),
+		Status:   c.Query("status"),
+		Method:   c.Query("method"),
+		ClientIP: c.Query("clientIp"),
+		ReplayOf: c.Query("replayOf"),
+	}
+	var err error
+	if filter.Query != "" {
+		if err = b.db.ValidateEventSearch(filter.Query); err != nil {
+			return filter, err
+		}
+	}
+	if filter.Since, err = timeQuery(c, "since"); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = timeQuery(c, "until"); err != nil {
+		return filter, err
+	}
+	if v := c.Query("statusCode"); v != "" {
+		if filter.StatusCode, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("invalid statusCode: %s", v)
+		}
+	}
+	if v := c.Query("minLatencyMs"); v != "" {
+		if filter.MinLatencyMs, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return filter, fmt.Errorf("invalid minLatencyMs: %s", v)
+		}
+	}
+	if filter.ConfigID, err = idQuery(c, "configId"); err != nil {
+		return filter, err
+	}
+	if filter.RuleID, err = idQuery(c, "ruleId"); err != nil {
+		return filter, err
+	}
+	if v := c.Query("unmatched"); v != "" {
+		unmatched, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid unmatched: %s", v)
+		}
+		filter.Unmatched = &unmatched
+	}
+	return filter, nil
+}
+
+// idQuery 读取 ID 类查询参数，未提供时返回 nil。
+func idQuery(c *gin.Context, name string) (*uint, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", name, v)
+	}
+	u := uint(id)
+	return &u, nil
+}
+
+// timeQuery 读取 RFC 3339 格式的时间查询参数，未提供时返回零值。
+func timeQuery(c *gin.Context, name string) (time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", name, v)
+	}
+	return t, nil
+}
+
+// sshEventFilterFromRequest 从查询参数中读取 SSH 历史记录的过滤条件，并检查全文检索表达式的语法。
+func (b *EventBroker) sshEventFilterFromRequest(c *gin.Context) (storage.SshEventFilter, error) {
+	filter := storage.SshEventFilter{
+		Project:  c.Query("project"),
+		Search:   c.Query("search"),
+		Query:    c.Query("q"),
+		Command:  c.Query("command"),
+		Status:   c.Query("status"),
+		Username: c.Query("username"),
+	}
+	var err error
+	if filter.Query != "" {
+		if err = b.db.ValidateSshEventSearch(filter.Query); err != nil {
+			return filter, err
+		}
+	}
+	if filter.Since, err = timeQuery(c, "since"); err != nil {
+		return filter, err
+	}
+	filter.Until, err = timeQuery(c, "until")
+	return filter, err
 }
 
 func (b *EventBroker) HandleGetSshHistory(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
-	project := c.Query("project")
-	search := c.Query("search")
+	page, err := pageRequestFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := b.sshEventFilterFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	events, total, err := b.db.GetSshEvents(page, pageSize, project, search)
+	events, result, err := b.db.GetSshEvents(filter, page)
+	if errors.Is(err, storage.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve SSH history"})
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"data":     events,
-		"total":    total,
-		"page":     page,
-		"pageSize": pageSize,
-	})
+	c.JSON(http.StatusOK, pageResponse(events, page, result))
 }
 
 func (b *EventBroker) HandleGetHistorySources(c *gin.Context) {
@@ -489,19 +877,15 @@ func (b *EventBroker) HandleGetServices(c *gin.Context) {
 	})
 }
 
+// CleanupPendingRequests 在最后一个监控页面断开时，用默认响应释放所有挂起的 HTTP 请求与 SSH 命令，
+// 历史记录由各自等待的协程更新。
 func (b *EventBroker) CleanupPendingRequests() {
-	b.pendingReqsMu.Lock()
-	defer b.pendingReqsMu.Unlock()
-	if len(b.pendingReqs) == 0 {
+	if b.pending.Len() == 0 {
 		return
 	}
-	log.Printf("Cleaning up %d pending requests...", len(b.pendingReqs))
-	for reqID, pr := range b.pendingReqs {
+	for _, reqID := range b.pending.Drain("Auto-Responded (Disconnect)") {
 		log.Printf("Auto-responding to pending request %s", reqID)
-		pr.ResponseChan <- pr.DefaultResponse
-		b.db.UpdateEventResponse(reqID, pr.DefaultResponse, "Auto-Responded (Disconnect)")
 	}
-	b.pendingReqs = make(map[string]*PendingRequest)
 }
 
 func (b *EventBroker) HandleSSEConnection(c *gin.Context) {
@@ -542,8 +926,8 @@ func (b *EventBroker) HandleSSEConnection(c *gin.Context) {
 	})
 }
 func (b *EventBroker) HandleDeleteConfig(c *gin.Context) {
-	endpoint := c.Param("endpoint")
-	if err := b.db.DeleteConfig(endpoint); err != nil {
+	method, endpoint, source := configKeyFromRequest(c)
+	if err := b.db.DeleteConfig(method, endpoint, source); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete configuration"})
 		return
 	}
@@ -0,0 +1,347 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// 导出格式对应的内容类型
+var (
+	historyExportTypes = map[string]string{
+		"jsonl": "application/x-ndjson",
+		"csv":   "text/csv; charset=utf-8",
+		"har":   "application/json",
+	}
+	sshHistoryExportTypes = map[string]string{
+		"jsonl": "application/x-ndjson",
+		"csv":   "text/csv; charset=utf-8",
+		"txt":   "text/plain; charset=utf-8",
+	}
+)
+
+// HandleExportHistory 以 JSONL、CSV 或 HAR 1.2 格式流式导出符合过滤条件的 HTTP 历史记录，过滤参数与 HandleGetHistory 相同。
+func (b *EventBroker) HandleExportHistory(c *gin.Context) {
+	filter, err := b.eventFilterFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "jsonl")
+	w, ok := startExport(c, "history", format, historyExportTypes)
+	if !ok {
+		return
+	}
+
+	switch format {
+	case "jsonl":
+		enc := newJSONLEncoder(w)
+		err = b.db.EachEvent(filter, func(e *storage.Event) error { return enc.Encode(e) })
+	case "csv":
+		err = writeEventsCSV(w, func(fn func(*storage.Event) error) error { return b.db.EachEvent(filter, fn) })
+	case "har":
+		err = writeEventsHAR(w, func(fn func(*storage.Event) error) error { return b.db.EachEvent(filter, fn) })
+	}
+	finishExport(c, w, "history", err)
+}
+
+// HandleExportSshHistory 以 JSONL、CSV 或文本会话记录格式流式导出符合过滤条件的 SSH 历史记录。
+func (b *EventBroker) HandleExportSshHistory(c *gin.Context) {
+	filter, err := b.sshEventFilterFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "jsonl")
+	w, ok := startExport(c, "ssh-history", format, sshHistoryExportTypes)
+	if !ok {
+		return
+	}
+
+	switch format {
+	case "jsonl":
+		enc := newJSONLEncoder(w)
+		err = b.db.EachSshEvent(filter, func(e *storage.SshEvent) error { return enc.Encode(e) })
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"requestId", "timestamp", "project", "command", "status", "response", "stderr", "exitCode"})
+		err = b.db.EachSshEvent(filter, func(e *storage.SshEvent) error {
+			return cw.Write([]string{e.RequestID, formatExportTime(e.Timestamp), e.Project, e.Command, e.Status, e.ResponseBody, e.Stderr, formatExitCode(e.ExitCode)})
+		})
+		// csv.Writer 与 w 共用同一个缓冲，出错时不能 Flush，否则会提前发出响应头
+		if err == nil {
+			cw.Flush()
+			err = cw.Error()
+		}
+	case "txt":
+		err = b.db.EachSshEvent(filter, func(e *storage.SshEvent) error {
+			header := fmt.Sprintf("# %s [%s] %s", formatExportTime(e.Timestamp), e.Project, e.Status)
+			if e.ExitCode != nil {
+				header += fmt.Sprintf(" (exit %d)", *e.ExitCode)
+			}
+			var output []string
+			if stdout := strings.TrimRight(e.ResponseBody, "\n"); stdout != "" || e.Stderr == "" {
+				output = append(output, stdout)
+			}
+			if e.Stderr != "" {
+				output = append(output, strings.TrimRight(e.Stderr, "\n"))
+			}
+			_, err := fmt.Fprintf(w, "%s\n$ %s\n%s\n\n", header, e.Command, strings.Join(output, "\n"))
+			return err
+		})
+	}
+	finishExport(c, w, "SSH history", err)
+}
+
+// startExport 检查导出格式并设置响应头，格式不支持时返回 400。响应头随第一次写出的内容一起发送，
+// 在此之前（缓冲未满、第一批记录尚未读出）出错仍可以返回错误响应。
+func startExport(c *gin.Context, name, format string, types map[string]string) (*bufio.Writer, bool) {
+	contentType, ok := types[format]
+	if !ok {
+		formats := make([]string, 0, len(types))
+		for f := range types {
+			formats = append(formats, f)
+		}
+		sort.Strings(formats)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of: " + strings.Join(formats, ", ")})
+		return nil, false
+	}
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102-150405"), format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	return bufio.NewWriter(c.Writer), true
+}
+
+// finishExport 写出缓冲中的剩余内容。出错时若响应尚未开始发送则改为返回 500，
+// 否则中断连接，使客户端看到下载失败而不是一个被截断却看似完整的文件。
+func finishExport(c *gin.Context, w *bufio.Writer, name string, err error) {
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		return
+	}
+	log.Printf("broker: Failed to export %s: %v", name, err)
+	if !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Disposition")
+		c.Writer.Header().Del("Content-Type")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export " + name})
+		return
+	}
+	panic(http.ErrAbortHandler)
+}
+
+func newJSONLEncoder(w io.Writer) *json.Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc
+}
+
+// formatExitCode 返回退出码的文本，尚未响应的记录为空。
+func formatExitCode(code *int) string {
+	if code == nil {
+		return ""
+	}
+	return strconv.Itoa(*code)
+}
+
+func formatExportTime(t time.Time) string {
+	return t.Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// writeEventsCSV 以 CSV 格式写出历史记录，请求头与响应头以 JSON 形式放在单独的列中。
+func writeEventsCSV(w io.Writer, each func(func(*storage.Event) error) error) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"requestId", "timestamp", "method", "endpoint", "query", "project", "source", "clientIp",
+		"configId", "ruleId", "status", "statusCode", "latencyMs",
+		"requestHeaders", "payload", "responseHeaders", "responseBody", "replayOf",
+	})
+	err := each(func(e *storage.Event) error {
+		requestHeaders, _ := json.Marshal(e.RequestHeaders)
+		responseHeaders, _ := json.Marshal(e.ResponseHeaders)
+		return cw.Write([]string{
+			e.RequestID, formatExportTime(e.Timestamp), e.Method, e.Endpoint, e.Query, e.Project, e.Source, e.ClientIP,
+			strconv.FormatUint(uint64(e.ConfigID), 10), strconv.FormatUint(uint64(e.RuleID), 10),
+			e.Status, strconv.Itoa(e.StatusCode), strconv.FormatInt(e.LatencyMs, 10),
+			string(requestHeaders), e.Payload, string(responseHeaders), e.ResponseBody, e.ReplayOf,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// HAR 1.2 中用到的结构，见 http://www.softwareishard.com/blog/har-12-spec/
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harTimings struct {
+	Send    int64 `json:"send"`
+	Wait    int64 `json:"wait"`
+	Receive int64 `json:"receive"`
+}
+
+// harEntry 的下划线字段是 HAR 允许的自定义字段，保存 mock 特有的信息。
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int64       `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+	RequestID       string      `json:"_requestId"`
+	Project         string      `json:"_project,omitempty"`
+	ClientIP        string      `json:"_clientIp,omitempty"`
+	ConfigID        uint        `json:"_configId,omitempty"`
+	RuleID          uint        `json:"_ruleId,omitempty"`
+	ReplayOf        string      `json:"_replayOf,omitempty"`
+}
+
+// writeEventsHAR 以 HAR 1.2 格式写出历史记录，逐条写出 entries 而不在内存中拼出整个文档。
+func writeEventsHAR(w io.Writer, each func(func(*storage.Event) error) error) error {
+	if _, err := io.WriteString(w, `{"log":{"version":"1.2","creator":{"name":"zyuc-mock","version":"1.0"},"entries":[`); err != nil {
+		return err
+	}
+	first := true
+	err := each(func(e *storage.Event) error {
+		entry, err := json.Marshal(newHAREntry(e))
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = w.Write(entry)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]}}")
+	return err
+}
+
+func newHAREntry(e *storage.Event) harEntry {
+	method := e.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	rawURL := "http://" + e.Source + e.Endpoint
+	values, _ := url.ParseQuery(e.Query)
+	if e.Query != "" {
+		rawURL += "?" + e.Query
+	}
+	request := harRequest{
+		Method:      method,
+		URL:         rawURL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harPairs(e.RequestHeaders),
+		QueryString: harPairs(values),
+		HeadersSize: -1,
+		BodySize:    len(e.Payload),
+	}
+	if e.Payload != "" {
+		request.PostData = &harPostData{MimeType: http.Header(e.RequestHeaders).Get("Content-Type"), Text: e.Payload}
+	}
+	return harEntry{
+		StartedDateTime: formatExportTime(e.Timestamp),
+		Time:            e.LatencyMs,
+		Request:         request,
+		Response: harResponse{
+			Status:      e.StatusCode,
+			StatusText:  http.StatusText(e.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harPairs(e.ResponseHeaders),
+			Content: harContent{
+				Size:     len(e.ResponseBody),
+				MimeType: http.Header(e.ResponseHeaders).Get("Content-Type"),
+				Text:     e.ResponseBody,
+			},
+			HeadersSize: -1,
+			BodySize:    len(e.ResponseBody),
+		},
+		Timings:   harTimings{Wait: e.LatencyMs},
+		Comment:   e.Status,
+		RequestID: e.RequestID,
+		Project:   e.Project,
+		ClientIP:  e.ClientIP,
+		ConfigID:  e.ConfigID,
+		RuleID:    e.RuleID,
+		ReplayOf:  e.ReplayOf,
+	}
+}
+
+// harPairs 把多值的头或查询参数展开为按名称排序的名值对。
+func harPairs(values map[string][]string) []harNameValue {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := []harNameValue{}
+	for _, name := range names {
+		for _, v := range values[name] {
+			pairs = append(pairs, harNameValue{Name: name, Value: v})
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestExportHistoryFailsBeforeSendingHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	b := newTestBroker(t)
+	sqlDB, err := b.db.DB.DB()
+	if err != nil {
+		t.Fatalf("DB: %v", err)
+	}
+	sqlDB.Close()
+
+	for _, format := range []string{"jsonl", "csv", "har"} {
+		t.Run(format, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/history/export?format="+format, nil)
+			b.HandleExportHistory(c)
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status %d, want 500", w.Code)
+			}
+			if w.Header().Get("Content-Disposition") != "" {
+				t.Fatalf("failed export was offered as an attachment")
+			}
+		})
+	}
+}
+
+func TestFinishExport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name      string
+		written   int // 出错前已写出的字节数，超过缓冲大小时响应已经开始发送
+		err       error
+		wantPanic bool
+		wantCode  int
+	}{
+		{"success", 10, nil, false, http.StatusOK},
+		{"error before anything was sent", 10, errors.New("boom"), false, http.StatusInternalServerError},
+		{"error mid-stream", 10000, errors.New("boom"), true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/history/export", nil)
+			w, ok := startExport(c, "history", "jsonl", historyExportTypes)
+			if !ok {
+				t.Fatalf("startExport refused jsonl")
+			}
+			w.WriteString(strings.Repeat("x", tt.written))
+
+			panicked := func() (panicked bool) {
+				defer func() {
+					if r := recover(); r != nil {
+						if r != http.ErrAbortHandler {
+							t.Fatalf("panic %v, want http.ErrAbortHandler", r)
+						}
+						panicked = true
+					}
+				}()
+				finishExport(c, w, "history", tt.err)
+				return false
+			}()
+			if panicked != tt.wantPanic {
+				t.Fatalf("panicked = %v, want %v", panicked, tt.wantPanic)
+			}
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep 是 JSONPath 中的一步：按键取值、按下标取值或通配，可带递归下降(..)。
+type jsonPathStep struct {
+	key       string
+	index     int
+	isIndex   bool
+	wildcard  bool
+	recursive bool
+}
+
+type jsonPath []jsonPathStep
+
+// parseJSONPath 解析 JSONPath 的常用子集：$、.key、..key、.*、[n]、[*]、['key']、["key"]。
+func parseJSONPath(expr string) (jsonPath, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("JSONPath must start with '$'")
+	}
+	var path jsonPath
+	i := 1
+	for i < len(expr) {
+		var step jsonPathStep
+		switch expr[i] {
+		case '.':
+			i++
+			if i < len(expr) && expr[i] == '.' {
+				step.recursive = true
+				i++
+			}
+			if i < len(expr) && expr[i] == '[' {
+				// "..[0]" / "..['key']"：交给下面的括号分支处理
+				if !step.recursive {
+					return nil, fmt.Errorf("unexpected '[' after '.' at position %d", i)
+				}
+				bracket, next, err := parseJSONPathBracket(expr, i)
+				if err != nil {
+					return nil, err
+				}
+				bracket.recursive = true
+				path = append(path, bracket)
+				i = next
+				continue
+			}
+			start := i
+			for i < len(expr) && expr[i] != '.' && expr[i] != '[' {
+				i++
+			}
+			name := expr[start:i]
+			if name == "" {
+				return nil, fmt.Errorf("empty key at position %d", start)
+			}
+			if name == "*" {
+				step.wildcard = true
+			} else {
+				step.key = name
+			}
+		case '[':
+			bracket, next, err := parseJSONPathBracket(expr, i)
+			if err != nil {
+				return nil, err
+			}
+			step = bracket
+			i = next
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", expr[i], i)
+		}
+		path = append(path, step)
+	}
+	return path, nil
+}
+
+// parseJSONPathBracket 解析从 expr[i] == '[' 开始的括号表达式，返回该步骤及其后的位置。
+func parseJSONPathBracket(expr string, i int) (jsonPathStep, int, error) {
+	var step jsonPathStep
+	end := strings.IndexByte(expr[i:], ']')
+	if end < 0 {
+		return step, 0, fmt.Errorf("unclosed '[' at position %d", i)
+	}
+	inner := strings.TrimSpace(expr[i+1 : i+end])
+	next := i + end + 1
+	switch {
+	case inner == "*":
+		step.wildcard = true
+	case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+		step.key = inner[1 : len(inner)-1]
+	default:
+		n, err := strconv.Atoi(inner)
+		if err != nil {
+			return step, 0, fmt.Errorf("invalid bracket expression [%s]", inner)
+		}
+		step.index = n
+		step.isIndex = true
+	}
+	return step, next, nil
+}
+
+// eval 返回路径在文档中命中的所有节点。
+func (p jsonPath) eval(doc interface{}) []interface{} {
+	nodes := []interface{}{doc}
+	for _, step := range p {
+		var next []interface{}
+		for _, n := range nodes {
+			if step.recursive {
+				for _, d := range jsonDescendants(n) {
+					next = append(next, step.apply(d)...)
+				}
+			} else {
+				next = append(next, step.apply(n)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+func (s jsonPathStep) apply(node interface{}) []interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			out := make([]interface{}, 0, len(keys))
+			for _, k := range keys {
+				out = append(out, v[k])
+			}
+			return out
+		}
+		if !s.isIndex {
+			if child, ok := v[s.key]; ok {
+				return []interface{}{child}
+			}
+		}
+	case []interface{}:
+		if s.wildcard {
+			return v
+		}
+		if s.isIndex {
+			idx := s.index
+			if idx < 0 {
+				idx += len(v)
+			}
+			if idx >= 0 && idx < len(v) {
+				return []interface{}{v[idx]}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonDescendants 返回节点自身及其全部后代，用于递归下降。
+func jsonDescendants(node interface{}) []interface{} {
+	out := []interface{}{node}
+	switch v := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out = append(out, jsonDescendants(v[k])...)
+		}
+	case []interface{}:
+		for _, child := range v {
+			out = append(out, jsonDescendants(child)...)
+		}
+	}
+	return out
+}
+
+// parseJSONBody 解析 JSON 请求体，数字保留原始文本以便精确比较。
+func parseJSONBody(body string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// jsonValueString 将 JSON 节点转换为用于比较的字符串：字符串取原值，其余取紧凑的 JSON 表示。
+func jsonValueString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	case nil:
+		return "null"
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,71 @@
+package broker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJSONPathEval(t *testing.T) {
+	const doc = `{
+		"order": {"id": 42, "paid": true, "note": null, "customer": {"name": "alice", "tags": ["vip", "cn"]}},
+		"items": [{"sku": "a-1", "qty": 2}, {"sku": "b-2", "qty": 1.50}],
+		"odd key": "x"
+	}`
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"$.order.id", []string{"42"}},
+		{"$.order.paid", []string{"true"}},
+		{"$.order.note", []string{"null"}},
+		{"$.order.customer", []string{`{"name":"alice","tags":["vip","cn"]}`}},
+		{"$.order.customer.tags[0]", []string{"vip"}},
+		{"$.order.customer.tags[-1]", []string{"cn"}},
+		{"$.order.customer.tags[5]", nil},
+		{"$.items[*].sku", []string{"a-1", "b-2"}},
+		{"$.items[1].qty", []string{"1.50"}},
+		{"$..sku", []string{"a-1", "b-2"}},
+		{"$..[0]", []string{`{"qty":2,"sku":"a-1"}`, "vip"}},
+		{"$.order.customer.*", []string{"alice", `["vip","cn"]`}},
+		{"$['odd key']", []string{"x"}},
+		{`$["order"]["id"]`, []string{"42"}},
+		{"$.missing", nil},
+		{"$.order.id.deeper", nil},
+	}
+	body, err := parseJSONBody(doc)
+	if err != nil {
+		t.Fatalf("parseJSONBody: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			path, err := parseJSONPath(tt.expr)
+			if err != nil {
+				t.Fatalf("parseJSONPath: %v", err)
+			}
+			var got []string
+			for _, node := range path.eval(body) {
+				got = append(got, jsonValueString(node))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONPathErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"order.id",
+		"$.",
+		"$.order..",
+		"$.[0]",
+		"$.items[0",
+		"$.items[x]",
+		"$order",
+	} {
+		if _, err := parseJSONPath(expr); err == nil {
+			t.Errorf("parseJSONPath(%q) succeeded, want error", expr)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// requestContext 汇总规则匹配所需的请求信息，JSON/XML 请求体在首次使用时解析并缓存。
+type requestContext struct {
+	Method     string
+	Path       string
+	PathParams map[string]string // 模板或正则接口路径捕获的路径变量
+	Header     http.Header
+	Query      url.Values
+	Body       string
+
+	jsonParsed bool
+	jsonDoc    interface{}
+	xmlParsed  bool
+	xmlDoc     *xmlNode
+}
+
+func newRequestContext(c *gin.Context, body string, pathParams map[string]string) *requestContext {
+	if pathParams == nil {
+		pathParams = map[string]string{}
+	}
+	return &requestContext{
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+		PathParams: pathParams,
+		Header:     c.Request.Header,
+		Query:      c.Request.URL.Query(),
+		Body:       body,
+	}
+}
+
+// jsonBody 返回解析后的 JSON 请求体，请求体不是合法 JSON 时返回 false。
+func (rc *requestContext) jsonBody() (interface{}, bool) {
+	if !rc.jsonParsed {
+		rc.jsonParsed = true
+		if doc, err := parseJSONBody(rc.Body); err == nil {
+			rc.jsonDoc = doc
+		}
+	}
+	return rc.jsonDoc, rc.jsonDoc != nil
+}
+
+// xmlBody 返回解析后的 XML 请求体，请求体不是合法 XML 时返回 false。
+func (rc *requestContext) xmlBody() (*xmlNode, bool) {
+	if !rc.xmlParsed {
+		rc.xmlParsed = true
+		if doc, err := parseXMLDocument(rc.Body); err == nil {
+			rc.xmlDoc = doc
+		}
+	}
+	return rc.xmlDoc, rc.xmlDoc != nil
+}
+
+// maxCompiledExprs 是编译缓存的容量，超出时清空重建；规则来自配置，表达式的种类通常远少于此。
+const maxCompiledExprs = 1024
+
+// compiledExprs 按表达式缓存编译好的正则、JSONPath 与 XPath。规则每个请求都从数据库重新读取，
+// 缓存使同一表达式只在校验或首次命中时编译一次。
+var compiledExprs = struct {
+	sync.Mutex
+	m map[string]interface{}
+}{m: make(map[string]interface{})}
+
+// compileCached 返回 key 对应的编译结果，缓存中没有时调用 compile 并缓存成功的结果。
+func compileCached(key string, compile func() (interface{}, error)) (interface{}, error) {
+	compiledExprs.Lock()
+	v, ok := compiledExprs.m[key]
+	compiledExprs.Unlock()
+	if ok {
+		return v, nil
+	}
+	v, err := compile()
+	if err != nil {
+		return nil, err
+	}
+	compiledExprs.Lock()
+	if len(compiledExprs.m) >= maxCompiledExprs {
+		compiledExprs.m = make(map[string]interface{})
+	}
+	compiledExprs.m[key] = v
+	compiledExprs.Unlock()
+	return v, nil
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	v, err := compileCached("regex\x00"+expr, func() (interface{}, error) { return regexp.Compile(expr) })
+	if err != nil {
+		return nil, err
+	}
+	return v.(*regexp.Regexp), nil
+}
+
+func compileJSONPath(expr string) (jsonPath, error) {
+	v, err := compileCached("jsonpath\x00"+expr, func() (interface{}, error) { return parseJSONPath(expr) })
+	if err != nil {
+		return nil, err
+	}
+	return v.(jsonPath), nil
+}
+
+// compileXPath 的缓存键包含命名空间映射，同一表达式在不同映射下编译结果不同。
+func compileXPath(expr string, namespaces map[string]string) (*xpathExpr, error) {
+	key := "xpath\x00" + expr
+	prefixes := make([]string, 0, len(namespaces))
+	for prefix := range namespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		key += "\x00" + prefix + "=" + namespaces[prefix]
+	}
+	v, err := compileCached(key, func() (interface{}, error) { return parseXPath(expr, namespaces) })
+	if err != nil {
+		return nil, err
+	}
+	return v.(*xpathExpr), nil
+}
+
+// validateMatcher 检查匹配条件树是否合法，包括正则、JSONPath、XPath 能否编译。
+func validateMatcher(m *storage.Matcher) error {
+	switch m.Type {
+	case storage.MatcherContains:
+		if m.Value == "" {
+			return fmt.Errorf("contains matcher requires a value")
+		}
+	case storage.MatcherRegex:
+		if _, err := compileRegex(m.Expr); err != nil {
+			return fmt.Errorf("invalid regex %q: %v", m.Expr, err)
+		}
+	case storage.MatcherJSONPath:
+		if _, err := compileJSONPath(m.Expr); err != nil {
+			return fmt.Errorf("invalid JSONPath %q: %v", m.Expr, err)
+		}
+		return validateMatchOp(m)
+	case storage.MatcherXPath:
+		if _, err := compileXPath(m.Expr, m.Namespaces); err != nil {
+			return fmt.Errorf("invalid XPath %q: %v", m.Expr, err)
+		}
+		return validateMatchOp(m)
+	case storage.MatcherHeader, storage.MatcherQuery, storage.MatcherPath:
+		if m.Name == "" {
+			return fmt.Errorf("%s matcher requires a name", m.Type)
+		}
+		return validateMatchOp(m)
+	case storage.MatcherMethod:
+		if m.Value == "" {
+			return fmt.Errorf("method matcher requires a value")
+		}
+	case storage.MatcherAnd, storage.MatcherOr:
+		if len(m.Children) == 0 {
+			return fmt.Errorf("%s matcher requires at least one child", m.Type)
+		}
+		for i := range m.Children {
+			if err := validateMatcher(&m.Children[i]); err != nil {
+				return err
+			}
+		}
+	case storage.MatcherNot:
+		if len(m.Children) != 1 {
+			return fmt.Errorf("not matcher requires exactly one child")
+		}
+		return validateMatcher(&m.Children[0])
+	default:
+		return fmt.Errorf("unknown matcher type %q", m.Type)
+	}
+	return nil
+}
+
+func validateMatchOp(m *storage.Matcher) error {
+	switch m.Op {
+	case "", storage.MatchOpEquals, storage.MatchOpContains, storage.MatchOpExists:
+	case storage.MatchOpRegex:
+		if _, err := compileRegex(m.Value); err != nil {
+			return fmt.Errorf("invalid regex %q: %v", m.Value, err)
+		}
+	default:
+		return fmt.Errorf("unknown op %q", m.Op)
+	}
+	return nil
+}
+
+// evalMatcher 判断请求是否满足匹配条件。表达式取自编译缓存；条件在保存时已校验，这里遇到无法编译的表达式时按未命中处理。
+func evalMatcher(m *storage.Matcher, rc *requestContext) bool {
+	switch m.Type {
+	case storage.MatcherContains:
+		return strings.Contains(rc.Body, m.Value)
+	case storage.MatcherRegex:
+		re, err := compileRegex(m.Expr)
+		return err == nil && re.MatchString(rc.Body)
+	case storage.MatcherJSONPath:
+		path, err := compileJSONPath(m.Expr)
+		if err != nil {
+			return false
+		}
+		doc, ok := rc.jsonBody()
+		if !ok {
+			return false
+		}
+		var values []string
+		for _, node := range path.eval(doc) {
+			values = append(values, jsonValueString(node))
+		}
+		return compareValues(m.Op, m.Value, values)
+	case storage.MatcherXPath:
+		expr, err := compileXPath(m.Expr, m.Namespaces)
+		if err != nil {
+			return false
+		}
+		doc, ok := rc.xmlBody()
+		if !ok {
+			return false
+		}
+		values := expr.eval(doc)
+		for i := range values {
+			values[i] = strings.TrimSpace(values[i])
+		}
+		return compareValues(m.Op, m.Value, values)
+	case storage.MatcherHeader:
+		return compareValues(m.Op, m.Value, rc.Header.Values(m.Name))
+	case storage.MatcherQuery:
+		return compareValues(m.Op, m.Value, rc.Query[m.Name])
+	case storage.MatcherPath:
+		v, ok := rc.PathParams[m.Name]
+		return ok && compareValues(m.Op, m.Value, []string{v})
+	case storage.MatcherMethod:
+		return strings.EqualFold(rc.Method, m.Value)
+	case storage.MatcherAnd:
+		for i := range m.Children {
+			if !evalMatcher(&m.Children[i], rc) {
+				return false
+			}
+		}
+		return true
+	case storage.MatcherOr:
+		for i := range m.Children {
+			if evalMatcher(&m.Children[i], rc) {
+				return true
+			}
+		}
+		return false
+	case storage.MatcherNot:
+		return len(m.Children) == 1 && !evalMatcher(&m.Children[0], rc)
+	}
+	return false
+}
+
+// compareValues 在取到的多个值中任意一个满足比较条件即视为命中。
+func compareValues(op, expected string, values []string) bool {
+	if op == storage.MatchOpExists {
+		return len(values) > 0
+	}
+	var re *regexp.Regexp
+	if op == storage.MatchOpRegex {
+		var err error
+		if re, err = compileRegex(expected); err != nil {
+			return false
+		}
+	}
+	for _, v := range values {
+		switch op {
+		case "", storage.MatchOpEquals:
+			if v == expected {
+				return true
+			}
+		case storage.MatchOpContains:
+			if strings.Contains(v, expected) {
+				return true
+			}
+		case storage.MatchOpRegex:
+			if re.MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ruleMatches 判断单条规则是否命中：Keyword 与 Matcher 都设置时需同时满足。
+func ruleMatches(rule *storage.ResponseRule, rc *requestContext) bool {
+	if rule.Keyword == "" && rule.Matcher == nil {
+		return false
+	}
+	if rule.Keyword != "" && !strings.Contains(rc.Body, rule.Keyword) {
+		return false
+	}
+	return rule.Matcher == nil || evalMatcher(rule.Matcher, rc)
+}
+
+// matchRule 按顺序（规则已按优先级排好）返回第一条命中的规则，没有命中时返回 nil。
+func matchRule(rules []storage.ResponseRule, rc *requestContext) *storage.ResponseRule {
+	for i := range rules {
+		if ruleMatches(&rules[i], rc) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
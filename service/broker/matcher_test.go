@@ -0,0 +1,133 @@
+package broker
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+func testRequestContext(body string) *requestContext {
+	return &requestContext{
+		Method:     "POST",
+		Path:       "/orders/42",
+		PathParams: map[string]string{"id": "42"},
+		Header:     http.Header{"X-Tenant": {"acme"}, "Content-Type": {"application/json"}},
+		Query:      url.Values{"debug": {"1"}, "tag": {"a", "b"}},
+		Body:       body,
+	}
+}
+
+func TestEvalMatcher(t *testing.T) {
+	const jsonBody = `{"order": {"id": 42, "status": "NEW", "items": [{"sku": "a-1"}, {"sku": "b-2"}]}}`
+	const xmlBody = `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" xmlns:o="urn:orders">
+  <s:Body><o:Cancel id="9" reason="dup">please</o:Cancel></s:Body>
+</s:Envelope>`
+	soap := map[string]string{"soap": "http://schemas.xmlsoap.org/soap/envelope/", "ord": "urn:orders"}
+	jsonpath := func(expr, op, value string) storage.Matcher {
+		return storage.Matcher{Type: storage.MatcherJSONPath, Expr: expr, Op: op, Value: value}
+	}
+	xpath := func(expr, op, value string) storage.Matcher {
+		return storage.Matcher{Type: storage.MatcherXPath, Expr: expr, Op: op, Value: value, Namespaces: soap}
+	}
+	header := storage.Matcher{Type: storage.MatcherHeader, Name: "X-Tenant", Value: "acme"}
+	wrongMethod := storage.Matcher{Type: storage.MatcherMethod, Value: "get"}
+
+	tests := []struct {
+		name    string
+		body    string
+		matcher storage.Matcher
+		want    bool
+	}{
+		{"contains", jsonBody, storage.Matcher{Type: storage.MatcherContains, Value: `"NEW"`}, true},
+		{"contains miss", jsonBody, storage.Matcher{Type: storage.MatcherContains, Value: "PAID"}, false},
+		{"regex", jsonBody, storage.Matcher{Type: storage.MatcherRegex, Expr: `"id":\s*\d+`}, true},
+		{"jsonpath equals", jsonBody, jsonpath("$.order.id", "", "42"), true},
+		{"jsonpath any value", jsonBody, jsonpath("$.order.items[*].sku", storage.MatchOpEquals, "b-2"), true},
+		{"jsonpath contains", jsonBody, jsonpath("$.order.status", storage.MatchOpContains, "EW"), true},
+		{"jsonpath regex", jsonBody, jsonpath("$..sku", storage.MatchOpRegex, `^b-\d$`), true},
+		{"jsonpath exists", jsonBody, jsonpath("$.order.coupon", storage.MatchOpExists, ""), false},
+		{"jsonpath on xml body", xmlBody, jsonpath("$.order.id", storage.MatchOpExists, ""), false},
+		{"xpath namespaced", xmlBody, xpath("/soap:Envelope/soap:Body/ord:Cancel/@id", "", "9"), true},
+		{"xpath wrong namespace", xmlBody, xpath("/soap:Envelope/soap:Body/soap:Cancel", storage.MatchOpExists, ""), false},
+		{"xpath attribute predicate", xmlBody, xpath("//Cancel[@reason='dup']/text()", "", "please"), true},
+		{"xpath position", xmlBody, xpath("/Envelope/Body/Cancel[1]/@reason", "", "dup"), true},
+		{"xpath text excludes child elements", xmlBody, xpath("/Envelope/Body/text()", storage.MatchOpContains, "please"), false},
+		{"header", jsonBody, header, true},
+		{"query", jsonBody, storage.Matcher{Type: storage.MatcherQuery, Name: "tag", Value: "b"}, true},
+		{"path", jsonBody, storage.Matcher{Type: storage.MatcherPath, Name: "id", Op: storage.MatchOpRegex, Value: `^\d+$`}, true},
+		{"path missing", jsonBody, storage.Matcher{Type: storage.MatcherPath, Name: "sku", Op: storage.MatchOpExists}, false},
+		{"method ignores case", jsonBody, storage.Matcher{Type: storage.MatcherMethod, Value: "post"}, true},
+		{"and", jsonBody, storage.Matcher{Type: storage.MatcherAnd, Children: []storage.Matcher{header, jsonpath("$.order.id", "", "42")}}, true},
+		{"and miss", jsonBody, storage.Matcher{Type: storage.MatcherAnd, Children: []storage.Matcher{header, wrongMethod}}, false},
+		{"or", jsonBody, storage.Matcher{Type: storage.MatcherOr, Children: []storage.Matcher{wrongMethod, header}}, true},
+		{"or miss", jsonBody, storage.Matcher{Type: storage.MatcherOr, Children: []storage.Matcher{wrongMethod}}, false},
+		{"not", jsonBody, storage.Matcher{Type: storage.MatcherNot, Children: []storage.Matcher{wrongMethod}}, true},
+		{"nested", jsonBody, storage.Matcher{Type: storage.MatcherAnd, Children: []storage.Matcher{
+			{Type: storage.MatcherNot, Children: []storage.Matcher{wrongMethod}},
+			{Type: storage.MatcherOr, Children: []storage.Matcher{wrongMethod, jsonpath("$.order.status", "", "NEW")}},
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateMatcher(&tt.matcher); err != nil {
+				t.Fatalf("validateMatcher: %v", err)
+			}
+			if got := evalMatcher(&tt.matcher, testRequestContext(tt.body)); got != tt.want {
+				t.Fatalf("evalMatcher = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMatcherErrors(t *testing.T) {
+	valid := storage.Matcher{Type: storage.MatcherContains, Value: "x"}
+	tests := []struct {
+		name    string
+		matcher storage.Matcher
+	}{
+		{"unknown type", storage.Matcher{Type: "glob"}},
+		{"contains without value", storage.Matcher{Type: storage.MatcherContains}},
+		{"regex", storage.Matcher{Type: storage.MatcherRegex, Expr: "(unclosed"}},
+		{"jsonpath", storage.Matcher{Type: storage.MatcherJSONPath, Expr: "order.id"}},
+		{"jsonpath op", storage.Matcher{Type: storage.MatcherJSONPath, Expr: "$.id", Op: "startsWith"}},
+		{"jsonpath regex value", storage.Matcher{Type: storage.MatcherJSONPath, Expr: "$.id", Op: storage.MatchOpRegex, Value: "[a-"}},
+		{"xpath", storage.Matcher{Type: storage.MatcherXPath, Expr: "/Envelope/Body["}},
+		{"xpath undeclared prefix", storage.Matcher{Type: storage.MatcherXPath, Expr: "/soap:Envelope"}},
+		{"header", storage.Matcher{Type: storage.MatcherHeader, Value: "acme"}},
+		{"query", storage.Matcher{Type: storage.MatcherQuery, Name: "tag", Op: "between"}},
+		{"path", storage.Matcher{Type: storage.MatcherPath, Name: "id", Op: storage.MatchOpRegex, Value: "*"}},
+		{"method", storage.Matcher{Type: storage.MatcherMethod}},
+		{"and without children", storage.Matcher{Type: storage.MatcherAnd}},
+		{"or with invalid child", storage.Matcher{Type: storage.MatcherOr, Children: []storage.Matcher{valid, {Type: storage.MatcherRegex, Expr: "("}}}},
+		{"not with two children", storage.Matcher{Type: storage.MatcherNot, Children: []storage.Matcher{valid, valid}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateMatcher(&tt.matcher); err == nil {
+				t.Fatalf("validateMatcher succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestCompiledExpressionsAreCached(t *testing.T) {
+	a, err := compileXPath("/Envelope/p:Body", map[string]string{"p": "urn:a"})
+	if err != nil {
+		t.Fatalf("compileXPath: %v", err)
+	}
+	b, _ := compileXPath("/Envelope/p:Body", map[string]string{"p": "urn:a"})
+	c, _ := compileXPath("/Envelope/p:Body", map[string]string{"p": "urn:b"})
+	if a != b {
+		t.Fatalf("the same expression was compiled twice")
+	}
+	if a == c {
+		t.Fatalf("expressions with different namespaces share a compiled form")
+	}
+	r1, _ := compileRegex(`^\d+$`)
+	r2, _ := compileRegex(`^\d+$`)
+	if r1 != r2 {
+		t.Fatalf("the same regex was compiled twice")
+	}
+}
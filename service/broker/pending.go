@@ -0,0 +1,195 @@
+package broker
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/service/pending"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// requirePrimary 检查本节点是否为主节点。挂起的请求只存在于主节点的内存中，
+// 从节点返回 503 与主节点地址，由调用方改向主节点发起请求。
+func (b *EventBroker) requirePrimary(c *gin.Context) bool {
+	isPrimary, primary := b.isPrimary()
+	if isPrimary {
+		return true
+	}
+	if primary == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No primary service available to handle the request."})
+		return false
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error":   "Pending requests are held by the primary broker.",
+		"primary": primary.Protocol + "://" + primary.Address,
+	})
+	return false
+}
+
+// HandleGetPending 列出当前挂起的 HTTP 请求与 SSH 命令，供重新连接的监控页面恢复错过的请求。
+func (b *EventBroker) HandleGetPending(c *gin.Context) {
+	if !b.requirePrimary(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": b.pending.List()})
+}
+
+// operatorFromRequest 返回发起操作的操作员，取自 X-Operator 请求头或 operator 查询参数。
+func operatorFromRequest(c *gin.Context) string {
+	if operator := c.GetHeader("X-Operator"); operator != "" {
+		return operator
+	}
+	return c.Query("operator")
+}
+
+// HandleClaimPending 认领挂起的请求，认领后只有该操作员可以响应、释放、拒绝或延长它。
+func (b *EventBroker) HandleClaimPending(c *gin.Context) {
+	if !b.requirePrimary(c) {
+		return
+	}
+	operator := operatorFromRequest(c)
+	if operator == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Operator is required (X-Operator header or operator query parameter)"})
+		return
+	}
+	info, err := b.pending.Claim(c.Param("requestId"), operator)
+	if err != nil {
+		writePendingError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// HandleUnclaimPending 放弃对挂起请求的认领。
+func (b *EventBroker) HandleUnclaimPending(c *gin.Context) {
+	if !b.requirePrimary(c) {
+		return
+	}
+	if err := b.pending.Unclaim(c.Param("requestId"), operatorFromRequest(c)); err != nil {
+		writePendingError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Claim released"})
+}
+
+// HandleReleasePending 用默认响应释放单个挂起的请求。
+func (b *EventBroker) HandleReleasePending(c *gin.Context) {
+	if !b.requirePrimary(c) {
+		return
+	}
+	if err := b.pending.Release(c.Param("requestId"), operatorFromRequest(c), "Released"); err != nil {
+		writePendingError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Request released with the default response"})
+}
+
+// HandleReleaseAllPending 用默认响应释放所有挂起的请求，跳过已被其他操作员认领的请求。
+func (b *EventBroker) HandleReleaseAllPending(c *gin.Context) {
+	if !b.requirePrimary(c) {
+		return
+	}
+	released, skipped := b.pending.ReleaseAll(operatorFromRequest(c), "Released")
+	if released == nil {
+		released = []string{}
+	}
+	if skipped == nil {
+		skipped = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"released": released, "skipped": skipped})
+}
+
+// HandleRejectPending 以指定的错误结束挂起的请求：HTTP 请求返回该状态码与错误信息，
+// SSH 命令把错误信息输出到标准错误并以 exitCode（默认 1）退出。
+func (b *EventBroker) HandleRejectPending(c *gin.Context) {
+	if !b.requirePrimary(c) {
+		return
+	}
+	var req struct {
+		StatusCode  int               `json:"statusCode"`
+		Message     string            `json:"message"`
+		ContentType string            `json:"contentType"`
+		Headers     map[string]string `json:"headers"`
+		ExitCode    *int              `json:"exitCode"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+	exitCode := 1
+	if req.ExitCode != nil {
+		exitCode = *req.ExitCode
+	}
+	if err := validateExitCode(exitCode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.StatusCode == 0 {
+		req.StatusCode = http.StatusInternalServerError
+	}
+	spec := storage.ResponseSpec{StatusCode: req.StatusCode, ContentType: req.ContentType, Headers: req.Headers}
+	if err := validateResponseSpec(spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid response: " + err.Error()})
+		return
+	}
+	if req.Message == "" {
+		req.Message = http.StatusText(req.StatusCode)
+	}
+	if spec.ContentType == "" {
+		spec.ContentType = "text/plain; charset=utf-8"
+	}
+
+	response := newMockResponse(req.Message, spec).pending()
+	if pendingReq, ok := b.pending.Get(c.Param("requestId")); ok && pendingReq.Type == pending.TypeSSH {
+		response = pending.Response{Stderr: req.Message, ExitCode: exitCode}
+	}
+	if err := b.pending.Respond(c.Param("requestId"), operatorFromRequest(c), pending.Reply{Response: response, Status: "Rejected"}); err != nil {
+		writePendingError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Request rejected"})
+}
+
+// HandleExtendPending 将挂起请求的自动返回时间推后指定秒数。
+func (b *EventBroker) HandleExtendPending(c *gin.Context) {
+	if !b.requirePrimary(c) {
+		return
+	}
+	var req struct {
+		Seconds int `json:"seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+	if req.Seconds <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "seconds must be greater than 0"})
+		return
+	}
+	info, err := b.pending.Extend(c.Param("requestId"), operatorFromRequest(c), time.Duration(req.Seconds)*time.Second)
+	if err != nil {
+		writePendingError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+func writePendingError(c *gin.Context, err error) {
+	var claimed *pending.ClaimedError
+	if errors.As(err, &claimed) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Request is claimed by " + claimed.Operator, "claimedBy": claimed.Operator})
+		return
+	}
+	switch err {
+	case pending.ErrNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Request ID not found or already processed"})
+	case pending.ErrHeldIndefinitely:
+		c.JSON(http.StatusConflict, gin.H{"error": "Request is held indefinitely"})
+	case pending.ErrNotClaimed:
+		c.JSON(http.StatusConflict, gin.H{"error": "Request is not claimed by this operator"})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "Request has already been answered"})
+	}
+}
@@ -0,0 +1,66 @@
+package broker
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// HandleGetProjectSettings 返回全局设置与各工程的设置。
+func (b *EventBroker) HandleGetProjectSettings(c *gin.Context) {
+	settings, err := b.db.GetAllProjectSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve project settings"})
+		return
+	}
+	globalHold := storage.HoldIndefinitely
+	if b.holdTimeout >= 0 {
+		globalHold = int(b.holdTimeout.Seconds())
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"global": gin.H{
+			"holdTimeout":    globalHold,
+			"historyMaxAge":  int(b.retention.MaxAge.Seconds()),
+			"historyMaxRows": b.retention.MaxRows,
+		},
+		"projects": settings,
+	})
+}
+
+// HandleSetProjectSetting 创建或替换工程设置，字段为 null 时沿用全局设置。
+func (b *EventBroker) HandleSetProjectSetting(c *gin.Context) {
+	var req struct {
+		Project        string `json:"project"`
+		HoldTimeout    *int   `json:"holdTimeout"`
+		HistoryMaxAge  *int   `json:"historyMaxAge"`
+		HistoryMaxRows *int   `json:"historyMaxRows"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+	if req.Project == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project cannot be empty"})
+		return
+	}
+	if req.HoldTimeout != nil && *req.HoldTimeout < storage.HoldIndefinitely {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "holdTimeout must be >= 0, or -1 to wait indefinitely"})
+		return
+	}
+	if (req.HistoryMaxAge != nil && *req.HistoryMaxAge < 0) || (req.HistoryMaxRows != nil && *req.HistoryMaxRows < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "historyMaxAge and historyMaxRows must be >= 0"})
+		return
+	}
+	setting := &storage.ProjectSetting{
+		Project:        req.Project,
+		HoldTimeout:    req.HoldTimeout,
+		HistoryMaxAge:  req.HistoryMaxAge,
+		HistoryMaxRows: req.HistoryMaxRows,
+	}
+	if err := b.db.SetProjectSetting(setting); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save project setting"})
+		return
+	}
+	c.JSON(http.StatusOK, setting)
+}
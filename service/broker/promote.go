@@ -0,0 +1,459 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+var (
+	jsonIdentRe   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	payloadWordRe = regexp.MustCompile(`[A-Za-z0-9_\-.:]{3,}`)
+)
+
+// promotedRule 是由历史记录生成的规则及其来源。
+type promotedRule struct {
+	Keyword        string           `json:"keyword,omitempty"`
+	Matcher        *storage.Matcher `json:"matcher"`
+	Response       string           `json:"response"`
+	StatusCode     int              `json:"statusCode,omitempty"`
+	ContentType    string           `json:"contentType,omitempty"`
+	FromRequestIDs []string         `json:"fromRequestIds"`
+}
+
+// promoteChange 是预览中的一项差异：op 为 change 表示字段变化，add 表示新增规则。
+type promoteChange struct {
+	Op    string      `json:"op"`
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new"`
+}
+
+// promotePlan 是将历史记录转换为配置的方案。
+type promotePlan struct {
+	Config   storage.Config  `json:"config"`
+	Rules    []promotedRule  `json:"rules"`
+	Existing bool            `json:"existing"`
+	Diff     []promoteChange `json:"diff"`
+	Warnings []string        `json:"warnings"`
+}
+
+// responseGroup 是响应体、状态码与内容类型都相同的一组历史记录。
+type responseGroup struct {
+	response string
+	spec     storage.ResponseSpec
+	events   []storage.Event
+}
+
+// recordedSpec 取出历史记录中返回给调用方的状态码与内容类型。
+func recordedSpec(e storage.Event) storage.ResponseSpec {
+	return storage.ResponseSpec{
+		StatusCode:  e.StatusCode,
+		ContentType: http.Header(e.ResponseHeaders).Get("Content-Type"),
+	}
+}
+
+// buildPromotePlan 以出现次数最多的响应为默认响应，其余每种响应生成一条规则，
+// 匹配条件取该组请求体共有、而其他请求体都没有的片段（JSON 字段、XML 元素或关键字）。
+func (b *EventBroker) buildPromotePlan(events []storage.Event, method, endpoint, source, project string) (*promotePlan, error) {
+	plan := &promotePlan{Rules: []promotedRule{}, Diff: []promoteChange{}, Warnings: []string{}}
+
+	var usable []storage.Event
+	for _, e := range events {
+		if e.Status == "Pending" || e.Status == "Cancelled" || strings.HasPrefix(e.Status, "Proxy Error") {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("event %s skipped: status %q has no response", e.RequestID, e.Status))
+			continue
+		}
+		usable = append(usable, e)
+	}
+	if len(usable) == 0 {
+		return nil, fmt.Errorf("none of the selected events has a response")
+	}
+
+	if endpoint == "" {
+		endpoint = usable[0].Endpoint
+		for _, e := range usable[1:] {
+			if e.Endpoint != endpoint {
+				return nil, fmt.Errorf("events have different endpoints (%s, %s); specify endpoint", endpoint, e.Endpoint)
+			}
+		}
+	}
+	pattern, err := storage.ParseEndpointPattern(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	// 生成的配置必须覆盖所有选中的记录，否则规则永远不会命中
+	for _, e := range usable {
+		if _, ok := pattern.Match(e.Endpoint); !ok {
+			return nil, fmt.Errorf("endpoint %s does not match the path %s of event %s", endpoint, e.Endpoint, e.RequestID)
+		}
+	}
+	if project == "" {
+		project = usable[0].Project
+	}
+
+	var groups []*responseGroup
+	byResponse := make(map[string]*responseGroup)
+	for _, e := range usable {
+		spec := recordedSpec(e)
+		key := fmt.Sprintf("%d\x00%s\x00%s", spec.StatusCode, spec.ContentType, e.ResponseBody)
+		g, ok := byResponse[key]
+		if !ok {
+			g = &responseGroup{response: e.ResponseBody, spec: spec}
+			byResponse[key] = g
+			groups = append(groups, g)
+		}
+		g.events = append(g.events, e)
+	}
+	// 出现次数最多的响应作为默认响应，次数相同时取最早出现的
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].events) > len(groups[j].events) })
+
+	existing, err := b.db.GetConfig(method, endpoint, source)
+	if err != nil {
+		return nil, err
+	}
+	plan.Config = storage.Config{Method: method, Endpoint: endpoint, Source: source, Remark: "Promoted from history"}
+	if existing != nil {
+		// 保留已有配置的其他设置，只替换工程、默认响应及其状态码与内容类型
+		plan.Existing = true
+		plan.Config.Remark = existing.Remark
+		plan.Config.HoldTimeout = existing.HoldTimeout
+		plan.Config.Headers = existing.Headers
+	}
+	plan.Config.Project = project
+	plan.Config.DefaultResponse = groups[0].response
+	plan.Config.StatusCode = groups[0].spec.StatusCode
+	plan.Config.ContentType = groups[0].spec.ContentType
+
+	for i, g := range groups[1:] {
+		var others []storage.Event
+		for j, o := range groups {
+			if j != i+1 {
+				others = append(others, o.events...)
+			}
+		}
+		matcher := distinguishingMatcher(g.events, others, method, pattern)
+		if matcher == nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("no payload fragment distinguishes the events returning response #%d; no rule created", i+2))
+			continue
+		}
+		rule := promotedRule{Matcher: matcher, Response: g.response, StatusCode: g.spec.StatusCode, ContentType: g.spec.ContentType}
+		for _, e := range g.events {
+			rule.FromRequestIDs = append(rule.FromRequestIDs, e.RequestID)
+		}
+		plan.Rules = append(plan.Rules, rule)
+	}
+
+	if existing != nil {
+		if existing.Project != plan.Config.Project {
+			plan.Diff = append(plan.Diff, promoteChange{Op: "change", Field: "project", Old: existing.Project, New: plan.Config.Project})
+		}
+		if existing.DefaultResponse != plan.Config.DefaultResponse {
+			plan.Diff = append(plan.Diff, promoteChange{Op: "change", Field: "defaultResponse", Old: existing.DefaultResponse, New: plan.Config.DefaultResponse})
+		}
+		if existing.StatusCode != plan.Config.StatusCode {
+			plan.Diff = append(plan.Diff, promoteChange{Op: "change", Field: "statusCode", Old: existing.StatusCode, New: plan.Config.StatusCode})
+		}
+		if existing.ContentType != plan.Config.ContentType {
+			plan.Diff = append(plan.Diff, promoteChange{Op: "change", Field: "contentType", Old: existing.ContentType, New: plan.Config.ContentType})
+		}
+	} else {
+		plan.Diff = append(plan.Diff,
+			promoteChange{Op: "add", Field: "config", New: fmt.Sprintf("%s %s", methodLabel(method), endpoint)},
+			promoteChange{Op: "change", Field: "defaultResponse", New: plan.Config.DefaultResponse},
+		)
+		if plan.Config.StatusCode != 0 {
+			plan.Diff = append(plan.Diff, promoteChange{Op: "change", Field: "statusCode", New: plan.Config.StatusCode})
+		}
+		if plan.Config.ContentType != "" {
+			plan.Diff = append(plan.Diff, promoteChange{Op: "change", Field: "contentType", New: plan.Config.ContentType})
+		}
+		if project != "" {
+			plan.Diff = append(plan.Diff, promoteChange{Op: "change", Field: "project", New: project})
+		}
+	}
+	var existingRules []storage.ResponseRule
+	if existing != nil {
+		existingRules = existing.Rules
+	}
+	kept := plan.Rules[:0]
+	for _, r := range plan.Rules {
+		if hasEquivalentRule(existingRules, r) {
+			continue
+		}
+		kept = append(kept, r)
+		plan.Diff = append(plan.Diff, promoteChange{Op: "add", Field: "rule", New: r})
+	}
+	plan.Rules = kept
+	return plan, nil
+}
+
+func methodLabel(method string) string {
+	if method == "" {
+		return "ANY"
+	}
+	return method
+}
+
+func hasEquivalentRule(rules []storage.ResponseRule, r promotedRule) bool {
+	for _, existing := range rules {
+		if existing.Response == r.Response && existing.Keyword == r.Keyword && reflect.DeepEqual(existing.Matcher, r.Matcher) &&
+			existing.StatusCode == r.StatusCode && existing.ContentType == r.ContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// distinguishingMatcher 找出对 group 中每条请求都命中、对 others 中任何请求都不命中的匹配条件，找不到时返回 nil。
+func distinguishingMatcher(group, others []storage.Event, method string, pattern *storage.EndpointPattern) *storage.Matcher {
+	contexts := func(events []storage.Event) []*requestContext {
+		out := make([]*requestContext, 0, len(events))
+		for _, e := range events {
+			params, ok := pattern.Match(e.Endpoint)
+			if !ok || params == nil {
+				params = map[string]string{}
+			}
+			out = append(out, &requestContext{
+				Method: method, Path: e.Endpoint, PathParams: params,
+				Header: http.Header{}, Query: url.Values{}, Body: e.Payload,
+			})
+		}
+		return out
+	}
+	groupRCs, otherRCs := contexts(group), contexts(others)
+	distinguishes := func(m *storage.Matcher) bool {
+		if validateMatcher(m) != nil {
+			return false
+		}
+		for _, rc := range groupRCs {
+			if !evalMatcher(m, rc) {
+				return false
+			}
+		}
+		for _, rc := range otherRCs {
+			if evalMatcher(m, rc) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// 优先选择取值种类少的字段（如类型、等级），比唯一的流水号更能概括同类请求
+	candidates := matcherCandidates(groupRCs[0])
+	all := append(append([]*requestContext{}, groupRCs...), otherRCs...)
+	cardinality := make([]int, len(candidates))
+	for i := range candidates {
+		cardinality[i] = distinctValues(&candidates[i], all)
+	}
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return cardinality[order[i]] < cardinality[order[j]] })
+	for _, i := range order {
+		if distinguishes(&candidates[i]) {
+			return &candidates[i]
+		}
+	}
+	// 没有共同片段时，为每条请求分别寻找条件并以 or 组合
+	if len(groupRCs) > 1 {
+		var children []storage.Matcher
+		for i := range group {
+			m := distinguishingMatcher(group[i:i+1], others, method, pattern)
+			if m == nil {
+				return nil
+			}
+			children = append(children, *m)
+		}
+		return &storage.Matcher{Type: storage.MatcherOr, Children: children}
+	}
+	return nil
+}
+
+// distinctValues 返回取值类条件在这些请求中取到的不同值的个数；关键字等条件返回一个较大的数，排在取值类条件之后。
+func distinctValues(m *storage.Matcher, rcs []*requestContext) int {
+	values := make(map[string]bool)
+	for _, rc := range rcs {
+		switch m.Type {
+		case storage.MatcherPath:
+			values[rc.PathParams[m.Name]] = true
+		case storage.MatcherJSONPath:
+			path, err := parseJSONPath(m.Expr)
+			if err != nil {
+				return len(rcs) + 1
+			}
+			if doc, ok := rc.jsonBody(); ok {
+				for _, node := range path.eval(doc) {
+					values[jsonValueString(node)] = true
+				}
+			}
+		case storage.MatcherXPath:
+			expr, err := parseXPath(m.Expr, m.Namespaces)
+			if err != nil {
+				return len(rcs) + 1
+			}
+			if doc, ok := rc.xmlBody(); ok {
+				for _, v := range expr.eval(doc) {
+					values[strings.TrimSpace(v)] = true
+				}
+			}
+		default:
+			return len(rcs) + 1
+		}
+	}
+	return len(values)
+}
+
+// matcherCandidates 按优先顺序列出请求可用作匹配条件的片段：路径变量、JSON 叶子字段、XML 叶子元素与属性、关键字，最后是整个请求体。
+func matcherCandidates(rc *requestContext) []storage.Matcher {
+	var out []storage.Matcher
+	names := make([]string, 0, len(rc.PathParams))
+	for name := range rc.PathParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out = append(out, storage.Matcher{Type: storage.MatcherPath, Name: name, Value: rc.PathParams[name]})
+	}
+	if doc, ok := rc.jsonBody(); ok {
+		var leaves []storage.Matcher
+		collectJSONLeaves(doc, "$", &leaves)
+		sort.SliceStable(leaves, func(i, j int) bool { return len(leaves[i].Expr) < len(leaves[j].Expr) })
+		out = append(out, leaves...)
+	}
+	if doc, ok := rc.xmlBody(); ok {
+		collectXMLLeaves(doc, "", &out)
+	}
+	seen := make(map[string]bool)
+	for _, word := range payloadWordRe.FindAllString(rc.Body, -1) {
+		if !seen[word] {
+			seen[word] = true
+			out = append(out, storage.Matcher{Type: storage.MatcherContains, Value: word})
+		}
+	}
+	if body := strings.TrimSpace(rc.Body); body != "" {
+		out = append(out, storage.Matcher{Type: storage.MatcherContains, Value: body})
+	}
+	return out
+}
+
+func collectJSONLeaves(node interface{}, path string, out *[]storage.Matcher) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			switch {
+			case jsonIdentRe.MatchString(k):
+				collectJSONLeaves(v[k], path+"."+k, out)
+			case !strings.ContainsAny(k, "']"):
+				collectJSONLeaves(v[k], path+"['"+k+"']", out)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			collectJSONLeaves(item, fmt.Sprintf("%s[%d]", path, i), out)
+		}
+	default:
+		*out = append(*out, storage.Matcher{Type: storage.MatcherJSONPath, Expr: path, Value: jsonValueString(v)})
+	}
+}
+
+// collectXMLLeaves 按文档顺序收集叶子元素的文本与属性，路径只使用本地名。
+func collectXMLLeaves(n *xmlNode, path string, out *[]storage.Matcher) {
+	for _, child := range n.Children {
+		childPath := path + "/" + child.Name.Local
+		for _, a := range child.Attrs {
+			if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" || a.Name.Space != "" {
+				continue
+			}
+			*out = append(*out, storage.Matcher{Type: storage.MatcherXPath, Expr: childPath + "/@" + a.Name.Local, Value: a.Value})
+		}
+		if len(child.Children) == 0 {
+			if text := strings.TrimSpace(child.stringValue()); text != "" {
+				*out = append(*out, storage.Matcher{Type: storage.MatcherXPath, Expr: childPath, Value: text})
+			}
+			continue
+		}
+		collectXMLLeaves(child, childPath, out)
+	}
+}
+
+// HandlePromoteEvents 将选中的历史记录转换为配置和规则。commit 为 false 时只返回预览与差异，为 true 时写入数据库。
+func (b *EventBroker) HandlePromoteEvents(c *gin.Context) {
+	var req struct {
+		RequestIDs []string `json:"requestIds"`
+		Method     string   `json:"method"`
+		Endpoint   string   `json:"endpoint"`
+		Source     string   `json:"source"`
+		Project    string   `json:"project"`
+		Commit     bool     `json:"commit"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+	if len(req.RequestIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "requestIds is required"})
+		return
+	}
+	seen := make(map[string]bool)
+	requestIDs := req.RequestIDs[:0]
+	for _, id := range req.RequestIDs {
+		if !seen[id] {
+			seen[id] = true
+			requestIDs = append(requestIDs, id)
+		}
+	}
+	req.RequestIDs = requestIDs
+	events, err := b.db.GetEventsByRequestIDs(req.RequestIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+		return
+	}
+	if len(events) != len(req.RequestIDs) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Some events were not found"})
+		return
+	}
+
+	plan, err := b.buildPromotePlan(events, strings.ToUpper(req.Method), req.Endpoint, req.Source, req.Project)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.Commit {
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
+	rules := make([]storage.ResponseRule, 0, len(plan.Rules))
+	for _, r := range plan.Rules {
+		rules = append(rules, storage.ResponseRule{
+			Keyword:      r.Keyword,
+			Matcher:      r.Matcher,
+			Response:     r.Response,
+			ResponseSpec: storage.ResponseSpec{StatusCode: r.StatusCode, ContentType: r.ContentType},
+		})
+	}
+	if err := b.db.SetConfigWithRules(&plan.Config, rules); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save config: " + err.Error()})
+		return
+	}
+	saved, err := b.db.GetConfig(plan.Config.Method, plan.Config.Endpoint, plan.Config.Source)
+	if err != nil || saved == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload saved config"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"config": saved, "diff": plan.Diff, "warnings": plan.Warnings})
+}
@@ -0,0 +1,47 @@
+package broker
+
+import (
+	"path/filepath"
+	"testing"
+
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+func newTestBroker(t *testing.T) *EventBroker {
+	t.Helper()
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	return New(db, "", false, 0, storage.RetentionPolicy{})
+}
+
+func TestBuildPromotePlanEndpointMustCoverEvents(t *testing.T) {
+	b := newTestBroker(t)
+	events := []storage.Event{
+		{RequestID: "r1", Endpoint: "/orders/1", Payload: `{"type":"a"}`, ResponseBody: "A", Status: "Auto-Responded", StatusCode: 200},
+		{RequestID: "r2", Endpoint: "/orders/2", Payload: `{"type":"b"}`, ResponseBody: "B", Status: "Auto-Responded", StatusCode: 200},
+	}
+	tests := []struct {
+		endpoint string
+		wantErr  bool
+	}{
+		{"", true}, // 路径不同时必须指定
+		{"/orders/{id}", false},
+		{"/orders/*", false},
+		{"/users/{id}", true},
+		{"/orders/1", true},
+		{`~^/orders/\d+$`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			plan, err := b.buildPromotePlan(events, "POST", tt.endpoint, "", "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(plan.Rules) != 1 {
+				t.Fatalf("got %d rules, want 1", len(plan.Rules))
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+const (
+	// replayEventKey 是 gin 上下文中保存重放生成的历史记录 ID 的键
+	replayEventKey = "replayEventID"
+	// maxDiffCells 限制逐行比较的规模（去掉相同的首尾行之后），超出时整体视为替换
+	maxDiffCells = 1 << 20
+)
+
+// replayKey 是请求上下文中标记重放请求的键，值为原始记录的请求 ID。
+// 重放只在主节点进程内发起，调用方无法通过请求头伪造。
+type replayKey struct{}
+
+// replayOf 返回重放请求对应的原始记录 ID，普通请求返回空字符串。
+func replayOf(c *gin.Context) string {
+	id, _ := c.Request.Context().Value(replayKey{}).(string)
+	return id
+}
+
+// HandleReplayEvent 重新发送一条历史记录中的请求，结果保存为关联到原记录的新历史记录并附上响应差异。
+// 未指定 target 时请求在主节点进程内按当前配置处理，否则发送到 target 指向的服务。
+func (b *EventBroker) HandleReplayEvent(c *gin.Context) {
+	var req struct {
+		Target string `json:"target"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+			return
+		}
+	}
+
+	original, err := b.db.GetEvent(c.Param("requestId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+	if original == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	base := strings.TrimSuffix(req.Target, "/")
+	if base == "" {
+		if !b.requirePrimary(c) {
+			return
+		}
+		base = "http://" + b.serverAddr
+	} else if u, err := url.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target must be an absolute http or https URL"})
+		return
+	}
+
+	target := base + original.Endpoint
+	if original.Query != "" {
+		target += "?" + original.Query
+	}
+	// 旧版本的历史记录没有保存请求方法
+	method := original.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	replayReq, err := http.NewRequest(method, target, bytes.NewReader([]byte(original.Payload)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create replay request: " + err.Error()})
+		return
+	}
+	for k, vs := range original.RequestHeaders {
+		if !hopHeaders[k] {
+			replayReq.Header[k] = vs
+		}
+	}
+
+	start := time.Now()
+	var resp *http.Response
+	replayEventID := ""
+	if req.Target == "" {
+		// 直接交给 handleCentralPublish 处理，由上下文标记为重放，不经过网络
+		replayReq.Header.Set("X-Forwarded-For-Service", original.Source)
+		replayReq.RemoteAddr = c.Request.RemoteAddr
+		replayReq = replayReq.WithContext(context.WithValue(c.Request.Context(), replayKey{}, original.RequestID))
+		w := httptest.NewRecorder()
+		rc, _ := gin.CreateTestContext(w)
+		rc.Request = replayReq
+		b.handleCentralPublish(rc)
+		resp = w.Result()
+		replayEventID = rc.GetString(replayEventKey)
+	} else if resp, err = b.httpClient.Do(replayReq); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to replay request: " + err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read replay response: " + err.Error()})
+		return
+	}
+	diff := diffLines(original.ResponseBody, string(respBody))
+
+	// 交给 mock 处理时历史记录已由 handleCentralPublish 生成，只需补上差异
+	var replayed *storage.Event
+	if id := replayEventID; id != "" {
+		if err := b.db.SetEventReplayDiff(id, diff); err != nil {
+			log.Printf("broker: Failed to save replay diff for %s: %v", id, err)
+		}
+		replayed, err = b.db.GetEvent(id)
+		if err != nil {
+			log.Printf("broker: Failed to load replayed event %s: %v", id, err)
+		}
+	}
+	if replayed == nil {
+		replayed = &storage.Event{
+			RequestID:       uuid.New().String(),
+			Endpoint:        original.Endpoint,
+			Project:         original.Project,
+			Payload:         original.Payload,
+			ResponseBody:    string(respBody),
+			Status:          "Replayed",
+			Source:          original.Source,
+			Method:          method,
+			Query:           original.Query,
+			RequestHeaders:  original.RequestHeaders,
+			StatusCode:      resp.StatusCode,
+			ResponseHeaders: resp.Header,
+			LatencyMs:       time.Since(start).Milliseconds(),
+			ReplayOf:        original.RequestID,
+			ReplayDiff:      diff,
+		}
+		if err := b.db.CreateEvent(replayed); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save replayed event: " + err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"event":      replayed,
+		"original":   original,
+		"statusCode": resp.StatusCode,
+		"identical":  diff == "",
+		"diff":       diff,
+	})
+}
+
+// diffLines 逐行比较两段文本，返回以 " "、"-"、"+" 开头的差异行，内容一致时返回空字符串。
+func diffLines(a, b string) string {
+	if a == b {
+		return ""
+	}
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	var out strings.Builder
+	// 相同的首尾行直接输出，只对中间不同的部分求最长公共子序列
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	for _, line := range x[:prefix] {
+		out.WriteString(" " + line + "\n")
+	}
+	diffMiddle(&out, x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])
+	for _, line := range x[len(x)-suffix:] {
+		out.WriteString(" " + line + "\n")
+	}
+	return out.String()
+}
+
+// diffMiddle 写出 x 与 y 的逐行差异，规模超过 maxDiffCells 时整体视为替换。
+func diffMiddle(out *strings.Builder, x, y []string) {
+	if len(x)*len(y) > maxDiffCells {
+		for _, line := range x {
+			out.WriteString("-" + line + "\n")
+		}
+		for _, line := range y {
+			out.WriteString("+" + line + "\n")
+		}
+		return
+	}
+
+	// lcs[i][j] 为 x[i:] 与 y[j:] 的最长公共子序列长度
+	lcs := make([][]int32, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			out.WriteString(" " + x[i] + "\n")
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("-" + x[i] + "\n")
+			i++
+		default:
+			out.WriteString("+" + y[j] + "\n")
+			j++
+		}
+	}
+}
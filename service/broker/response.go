@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/service/pending"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// MockResponse 是返回给调用方的完整响应。
+type MockResponse struct {
+	StatusCode  int               `json:"statusCode"`
+	ContentType string            `json:"contentType"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body"`
+}
+
+// newMockResponse 根据响应体和配置的响应规格构造响应，未指定的状态码和内容类型取默认值。
+func newMockResponse(body string, spec storage.ResponseSpec) MockResponse {
+	resp := MockResponse{
+		StatusCode:  spec.StatusCode,
+		ContentType: spec.ContentType,
+		Headers:     spec.Headers,
+		Body:        body,
+	}
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	if resp.ContentType == "" {
+		resp.ContentType = storage.DefaultContentType
+	}
+	return resp
+}
+
+// pending 转换为挂起请求的默认响应。
+func (r MockResponse) pending() pending.Response {
+	return pending.Response{StatusCode: r.StatusCode, ContentType: r.ContentType, Headers: r.Headers, Body: r.Body}
+}
+
+// mockResponseFrom 取出挂起请求回复中 HTTP 响应使用的部分。
+func mockResponseFrom(r pending.Response) MockResponse {
+	return MockResponse{StatusCode: r.StatusCode, ContentType: r.ContentType, Headers: r.Headers, Body: r.Body}
+}
+
+// write 将响应写回调用方。
+func (r MockResponse) write(c *gin.Context) {
+	for k, v := range r.Headers {
+		c.Header(k, v)
+	}
+	c.Data(r.StatusCode, r.ContentType, []byte(r.Body))
+}
+
+// recordResponse 在响应写出后把状态码、响应头与处理耗时记入历史记录。
+func recordResponse(c *gin.Context, event *storage.Event, start time.Time) {
+	event.StatusCode = c.Writer.Status()
+	event.ResponseHeaders = c.Writer.Header().Clone()
+	event.LatencyMs = time.Since(start).Milliseconds()
+}
+
+// validateResponseSpec 检查状态码与响应头是否合法。
+func validateResponseSpec(spec storage.ResponseSpec) error {
+	if spec.StatusCode != 0 && (spec.StatusCode < 100 || spec.StatusCode > 599) {
+		return fmt.Errorf("status code %d out of range", spec.StatusCode)
+	}
+	for k := range spec.Headers {
+		if k == "" {
+			return fmt.Errorf("header name cannot be empty")
+		}
+	}
+	return nil
+}
+
+// validateExitCode 检查 SSH 命令的退出码，SSH 协议只传递 0 到 255。
+func validateExitCode(code int) error {
+	if code < 0 || code > 255 {
+		return fmt.Errorf("exitCode must be between 0 and 255")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package broker
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// HandlePurgeHistory 物理删除符合过滤条件的 HTTP 历史记录，过滤参数与 HandleGetHistory 相同。
+// 不带任何过滤条件时必须指定 all=true 才会清空全部记录。
+func (b *EventBroker) HandlePurgeHistory(c *gin.Context) {
+	filter, err := b.eventFilterFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter == (storage.EventFilter{}) && c.Query("all") != "true" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Specify at least one filter, or all=true to delete the whole history"})
+		return
+	}
+	deleted, err := b.db.PurgeEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge history: " + err.Error()})
+		return
+	}
+	b.vacuumIfRequested(c)
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// HandlePurgeSshHistory 物理删除符合过滤条件的 SSH 历史记录，过滤参数与 HandleGetSshHistory 相同。
+func (b *EventBroker) HandlePurgeSshHistory(c *gin.Context) {
+	filter, err := b.sshEventFilterFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter == (storage.SshEventFilter{}) && c.Query("all") != "true" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Specify at least one filter, or all=true to delete the whole history"})
+		return
+	}
+	deleted, err := b.db.PurgeSshEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge SSH history: " + err.Error()})
+		return
+	}
+	b.vacuumIfRequested(c)
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// vacuumIfRequested 在请求带 vacuum=true 时整理数据库文件，失败只记录日志。
+func (b *EventBroker) vacuumIfRequested(c *gin.Context) {
+	if c.Query("vacuum") != "true" {
+		return
+	}
+	if err := b.db.Vacuum(); err != nil {
+		log.Printf("broker: VACUUM failed: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// HandleGetSshProfiles 返回所有设备配置。
+func (b *EventBroker) HandleGetSshProfiles(c *gin.Context) {
+	profiles, err := b.db.GetAllSshProfiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve SSH profiles"})
+		return
+	}
+	c.JSON(http.StatusOK, profiles)
+}
+
+// HandleSetSshProfile 按名称创建或更新设备配置。modes 省略时使用 Cisco IOS 风格的用户、特权与配置模式，
+// transitions 省略时同时使用默认的切换命令；initialMode 省略时为第一个模式。
+func (b *EventBroker) HandleSetSshProfile(c *gin.Context) {
+	var req struct {
+		Name        string                  `json:"name"`
+		Hostname    string                  `json:"hostname"`
+		Banner      string                  `json:"banner"`
+		InitialMode string                  `json:"initialMode"`
+		Modes       []storage.SshMode       `json:"modes"`
+		Transitions []storage.SshTransition `json:"transitions"`
+		IsDefault   bool                    `json:"isDefault"`
+		Remark      string                  `json:"remark"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+	profile := &storage.SshProfile{
+		Name:        strings.TrimSpace(req.Name),
+		Hostname:    req.Hostname,
+		Banner:      req.Banner,
+		InitialMode: req.InitialMode,
+		Modes:       req.Modes,
+		Transitions: req.Transitions,
+		IsDefault:   req.IsDefault,
+		Remark:      req.Remark,
+	}
+	if profile.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name cannot be empty"})
+		return
+	}
+	if profile.Hostname == "" {
+		profile.Hostname = profile.Name
+	}
+	if len(profile.Modes) == 0 {
+		profile.Modes = storage.DefaultSshModes()
+		if profile.Transitions == nil {
+			profile.Transitions = storage.DefaultSshTransitions()
+		}
+	}
+	if profile.InitialMode == "" {
+		profile.InitialMode = profile.Modes[0].Name
+	}
+	if err := validateSshProfile(profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid profile: " + err.Error()})
+		return
+	}
+
+	if err := b.db.SetSshProfile(profile); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save SSH profile"})
+		return
+	}
+	saved, err := b.db.GetSshProfile(profile.Name)
+	if err != nil || saved == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload saved SSH profile"})
+		return
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+// HandleDeleteSshProfile 删除设备配置，使用该配置的用户改为使用默认配置。
+func (b *EventBroker) HandleDeleteSshProfile(c *gin.Context) {
+	if err := b.db.DeleteSshProfile(c.Param("name")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete SSH profile"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "SSH profile deleted successfully"})
+}
+
+// validateSshProfile 检查模式名称不重复，初始模式与切换命令引用的模式都已定义。
+func validateSshProfile(profile *storage.SshProfile) error {
+	seen := make(map[string]bool, len(profile.Modes))
+	for i, m := range profile.Modes {
+		if m.Name == "" {
+			return fmt.Errorf("modes[%d]: name cannot be empty", i)
+		}
+		if seen[m.Name] {
+			return fmt.Errorf("modes[%d]: duplicate mode %q", i, m.Name)
+		}
+		seen[m.Name] = true
+	}
+	if !seen[profile.InitialMode] {
+		return fmt.Errorf("initialMode %q is not defined in modes", profile.InitialMode)
+	}
+	for i, t := range profile.Transitions {
+		if storage.NormalizeSshCommand(t.Command) == "" {
+			return fmt.Errorf("transitions[%d]: command cannot be empty", i)
+		}
+		if t.From != "" && !seen[t.From] {
+			return fmt.Errorf("transitions[%d]: mode %q is not defined in modes", i, t.From)
+		}
+		if !seen[t.To] {
+			return fmt.Errorf("transitions[%d]: mode %q is not defined in modes", i, t.To)
+		}
+	}
+	return nil
+}
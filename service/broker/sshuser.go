@@ -0,0 +1,150 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// HandleGetSshUsers 返回所有 SSH 用户，不含密码与质询的回答。
+func (b *EventBroker) HandleGetSshUsers(c *gin.Context) {
+	users, err := b.db.GetAllSshUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve SSH users"})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// sshChallengeInput 是请求中的质询，回答只在写入时提交，不会在查询时返回。
+type sshChallengeInput struct {
+	Prompt string
+	Answer *string // 省略时保留该用户同一提问原有的回答
+	Echo   bool
+}
+
+// HandleSetSshUser 按用户名创建或更新 SSH 用户。password 省略时保留原密码，为空字符串时清除密码。
+func (b *EventBroker) HandleSetSshUser(c *gin.Context) {
+	var req struct {
+		Username       string              `json:"username"`
+		Password       *string             `json:"password"`
+		AuthorizedKeys []string            `json:"authorizedKeys"`
+		Challenges     []sshChallengeInput `json:"challenges"`
+		Project        string              `json:"project"`
+		Profile        string              `json:"profile"`
+		Disabled       bool                `json:"disabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+	user := &storage.SshUser{
+		Username: strings.TrimSpace(req.Username),
+		Project:  req.Project,
+		Profile:  req.Profile,
+		Disabled: req.Disabled,
+	}
+	if user.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username cannot be empty"})
+		return
+	}
+	keys, err := normalizeAuthorizedKeys(req.AuthorizedKeys)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user.AuthorizedKeys = keys
+
+	if user.Profile != "" {
+		profile, err := b.db.GetSshProfile(user.Profile)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up SSH profile"})
+			return
+		}
+		if profile == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("SSH profile %q does not exist", user.Profile)})
+			return
+		}
+	}
+
+	existing, err := b.db.GetSshUser(user.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up SSH user"})
+		return
+	}
+	if req.Password != nil {
+		if err := user.SetPassword(*req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password: " + err.Error()})
+			return
+		}
+	} else if existing != nil {
+		user.PasswordHash = existing.PasswordHash
+	}
+
+	challenges := make([]storage.SshChallenge, len(req.Challenges))
+	answers := make([]string, len(req.Challenges))
+	answerHashes := make([]string, len(req.Challenges))
+	for i, ch := range req.Challenges {
+		if ch.Prompt == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("challenges[%d]: prompt cannot be empty", i)})
+			return
+		}
+		challenges[i] = storage.SshChallenge{Prompt: ch.Prompt, Echo: ch.Echo}
+		if ch.Answer != nil {
+			answers[i] = *ch.Answer
+			continue
+		}
+		if existing != nil {
+			answerHashes[i] = existing.ChallengeAnswerHash(ch.Prompt)
+		}
+		if answerHashes[i] == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("challenges[%d]: answer is required for a new prompt", i)})
+			return
+		}
+	}
+	if err := user.SetChallenges(challenges, answers, answerHashes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid challenge answer: " + err.Error()})
+		return
+	}
+
+	if err := b.db.SetSshUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save SSH user"})
+		return
+	}
+	saved, err := b.db.GetSshUser(user.Username)
+	if err != nil || saved == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload saved SSH user"})
+		return
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+// HandleDeleteSshUser 删除 SSH 用户。
+func (b *EventBroker) HandleDeleteSshUser(c *gin.Context) {
+	if err := b.db.DeleteSshUser(c.Param("username")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete SSH user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "SSH user deleted successfully"})
+}
+
+// normalizeAuthorizedKeys 检查每个公钥都是合法的 authorized_keys 行，并统一为“类型 公钥 注释”的形式。
+func normalizeAuthorizedKeys(lines []string) ([]string, error) {
+	keys := make([]string, 0, len(lines))
+	for i, line := range lines {
+		key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("authorizedKeys[%d]: %v", i, err)
+		}
+		normalized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		if comment != "" {
+			normalized += " " + comment
+		}
+		keys = append(keys, normalized)
+	}
+	return keys, nil
+}
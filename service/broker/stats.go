@@ -0,0 +1,59 @@
+package broker
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// HandleGetStats 统计历史记录的调用次数、响应方式占比、未命中配置的次数与耗时分布。
+// type=ssh 时统计 SSH 历史记录；groupBy（逗号分隔）与 bucket 指定分组方式，过滤参数与对应的历史记录查询相同。
+func (b *EventBroker) HandleGetStats(c *gin.Context) {
+	req := storage.StatsRequest{Bucket: c.Query("bucket")}
+	for _, field := range strings.Split(c.Query("groupBy"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			req.GroupBy = append(req.GroupBy, field)
+		}
+	}
+
+	var report storage.StatsReport
+	switch c.DefaultQuery("type", "http") {
+	case "http":
+		filter, err := b.eventFilterFromRequest(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		report, err = b.db.EventStats(filter, req)
+		if err != nil {
+			statsError(c, err)
+			return
+		}
+	case "ssh":
+		filter, err := b.sshEventFilterFromRequest(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		report, err = b.db.SshEventStats(filter, req)
+		if err != nil {
+			statsError(c, err)
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be http or ssh"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func statsError(c *gin.Context, err error) {
+	if errors.Is(err, storage.ErrInvalidStats) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute stats: " + err.Error()})
+}
@@ -0,0 +1,187 @@
+package broker
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// templateData 是响应模板可以访问的请求数据，例如 {{.PathParams.id}}、{{.JSON.user.name}}、
+// {{.XML.Envelope.Body.GetUser.phone}}、{{.Query.page}}、{{.Headers.Authorization}}。
+type templateData struct {
+	RequestID  string
+	Method     string
+	Path       string
+	PathParams map[string]string
+	Query      map[string]string
+	Headers    map[string]string
+	Body       string
+	JSON       interface{}
+	XML        map[string]interface{}
+}
+
+func newTemplateData(reqID string, rc *requestContext) templateData {
+	data := templateData{
+		RequestID:  reqID,
+		Method:     rc.Method,
+		Path:       rc.Path,
+		PathParams: rc.PathParams,
+		Query:      firstValues(rc.Query),
+		Headers:    firstValues(rc.Header),
+		Body:       rc.Body,
+	}
+	if doc, ok := rc.jsonBody(); ok {
+		data.JSON = doc
+	}
+	if doc, ok := rc.xmlBody(); ok {
+		data.XML = doc.toMap()
+	}
+	return data
+}
+
+func firstValues(values map[string][]string) map[string]string {
+	out := make(map[string]string, len(values))
+	for k, vs := range values {
+		if len(vs) > 0 {
+			out[k] = vs[0]
+		}
+	}
+	return out
+}
+
+// toMap 将 XML 文档转换为按本地名索引的嵌套 map：叶子元素取文本，属性以 "@名称" 为键，重复出现的元素合并为列表。
+func (n *xmlNode) toMap() map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, child := range n.Children {
+		var value interface{}
+		if len(child.Children) == 0 && len(child.Attrs) == 0 {
+			value = strings.TrimSpace(child.stringValue())
+		} else {
+			m := child.toMap()
+			for _, a := range child.Attrs {
+				if a.Name.Space != "xmlns" && a.Name.Local != "xmlns" {
+					m["@"+a.Name.Local] = a.Value
+				}
+			}
+			if len(child.Children) == 0 {
+				m["#text"] = strings.TrimSpace(child.stringValue())
+			}
+			value = m
+		}
+		key := child.Name.Local
+		switch existing := out[key].(type) {
+		case nil:
+			out[key] = value
+		case []interface{}:
+			out[key] = append(existing, value)
+		default:
+			out[key] = []interface{}{existing, value}
+		}
+	}
+	return out
+}
+
+// templateFuncs 返回响应模板可用的辅助函数，其中 query/header/jsonPath/xpath 绑定到当前请求。
+func templateFuncs(rc *requestContext) template.FuncMap {
+	return template.FuncMap{
+		"now": func(layout ...string) string {
+			if len(layout) > 0 {
+				return time.Now().Format(layout[0])
+			}
+			return time.Now().Format(time.RFC3339)
+		},
+		"timestamp":   func() int64 { return time.Now().Unix() },
+		"timestampMs": func() int64 { return time.Now().UnixMilli() },
+		"uuid":        func() string { return uuid.New().String() },
+		"randInt":     randInt,
+		"randDigits":  func(n int) string { return randFrom("0123456789", n) },
+		"randString": func(n int) string {
+			return randFrom("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", n)
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"default": func(def string, value interface{}) string {
+			if s := fmt.Sprint(value); value != nil && s != "" {
+				return s
+			}
+			return def
+		},
+		"query": func(name string) string { return rc.Query.Get(name) },
+		"header": func(name string) string {
+			return rc.Header.Get(http.CanonicalHeaderKey(name))
+		},
+		"jsonPath": func(expr string) (string, error) {
+			path, err := parseJSONPath(expr)
+			if err != nil {
+				return "", err
+			}
+			doc, ok := rc.jsonBody()
+			if !ok {
+				return "", nil
+			}
+			if nodes := path.eval(doc); len(nodes) > 0 {
+				return jsonValueString(nodes[0]), nil
+			}
+			return "", nil
+		},
+		"xpath": func(expr string) (string, error) {
+			x, err := parseXPath(expr, nil)
+			if err != nil {
+				return "", err
+			}
+			doc, ok := rc.xmlBody()
+			if !ok {
+				return "", nil
+			}
+			if values := x.eval(doc); len(values) > 0 {
+				return strings.TrimSpace(values[0]), nil
+			}
+			return "", nil
+		},
+	}
+}
+
+// renderResponse 将响应体作为模板渲染；不含 {{ 的响应体原样返回，避免解析普通文本。
+func renderResponse(body, reqID string, rc *requestContext) (string, error) {
+	if !strings.Contains(body, "{{") {
+		return body, nil
+	}
+	tmpl, err := template.New("response").Funcs(templateFuncs(rc)).Option("missingkey=zero").Parse(body)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, newTemplateData(reqID, rc)); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func randInt(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randInt: max %d is less than min %d", max, min)
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max-min+1)))
+	if err != nil {
+		return 0, err
+	}
+	return min + int(n.Int64()), nil
+}
+
+func randFrom(alphabet string, n int) string {
+	out := make([]byte, n)
+	for i := range out {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			idx = big.NewInt(0)
+		}
+		out[i] = alphabet[idx.Int64()]
+	}
+	return string(out)
+}
@@ -0,0 +1,152 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// HandleGetUnmatched 按方法、路径与设备列出未命中任何配置的请求及其次数和最近一条样本，过滤参数与 HandleGetHistory 相同。
+// 默认不列出此后已补上配置的请求，includeConfigured=true 时一并列出。
+func (b *EventBroker) HandleGetUnmatched(c *gin.Context) {
+	filter, err := b.eventFilterFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	items, err := b.db.GetUnmatchedEndpoints(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve unmatched requests"})
+		return
+	}
+	if c.Query("includeConfigured") != "true" {
+		kept := items[:0]
+		for _, item := range items {
+			if !item.Configured {
+				kept = append(kept, item)
+			}
+		}
+		items = kept
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// HandleCreateConfigFromUnmatched 以一条未命中配置的历史记录为样本创建配置，方法、路径与设备默认同样本，
+// 默认响应为空，由操作员之后填写。覆盖后的方法、路径或设备必须仍能匹配样本，否则返回 400。样本并非未命中的请求，或现在已有配置能匹配样本、
+// 或同一方法、路径与设备已有配置时返回 409。
+func (b *EventBroker) HandleCreateConfigFromUnmatched(c *gin.Context) {
+	var req struct {
+		Method          *string `json:"method"`
+		Endpoint        string  `json:"endpoint"`
+		Source          *string `json:"source"`
+		Project         string  `json:"project"`
+		Remark          string  `json:"remark"`
+		DefaultResponse *string `json:"defaultResponse"`
+		StatusCode      int     `json:"statusCode"`
+		ContentType     string  `json:"contentType"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+			return
+		}
+	}
+
+	sample, err := b.db.GetEvent(c.Param("requestId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+	if sample == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	if !sample.Unmatched {
+		c.JSON(http.StatusConflict, gin.H{"error": "Event " + sample.RequestID + " was not an unmatched request"})
+		return
+	}
+	covering, _, err := b.db.GetConfigForRequest(sample.Method, sample.Endpoint, sample.Source)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up config"})
+		return
+	}
+	if covering != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("config for %s %s already matches this request", methodLabel(covering.Method), covering.Endpoint), "config": covering})
+		return
+	}
+
+	method := sample.Method
+	if req.Method != nil {
+		method = strings.ToUpper(*req.Method)
+	}
+	endpoint := req.Endpoint
+	if endpoint == "" {
+		endpoint = sample.Endpoint
+	}
+	// 模板或正则路径必须能匹配样本，否则创建的配置仍然覆盖不到这类请求
+	pattern, err := storage.ParseEndpointPattern(endpoint)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid endpoint: " + err.Error()})
+		return
+	}
+	if _, ok := pattern.Match(sample.Endpoint); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("endpoint %s does not match the sample path %s", endpoint, sample.Endpoint)})
+		return
+	}
+	source := sample.Source
+	if req.Source != nil {
+		source = *req.Source
+	}
+	// 方法与设备同样只能放宽为任意，不能换成样本以外的值
+	if method != "" && method != sample.Method {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("method %s does not match the sample method %s", method, sample.Method)})
+		return
+	}
+	if source != "" && source != sample.Source {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("source %s does not match the sample source %s", source, sample.Source)})
+		return
+	}
+	spec := storage.ResponseSpec{StatusCode: req.StatusCode, ContentType: req.ContentType}
+	if err := validateResponseSpec(spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid response: " + err.Error()})
+		return
+	}
+
+	existing, err := b.db.GetConfig(method, endpoint, source)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up config"})
+		return
+	}
+	if existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("config for %s %s already exists", methodLabel(method), endpoint), "config": existing})
+		return
+	}
+
+	config := &storage.Config{
+		Method:       method,
+		Endpoint:     endpoint,
+		Source:       source,
+		Project:      req.Project,
+		Remark:       req.Remark,
+		ResponseSpec: spec,
+	}
+	if config.Remark == "" {
+		config.Remark = "Created from unmatched request " + sample.RequestID
+	}
+	if req.DefaultResponse != nil {
+		config.DefaultResponse = *req.DefaultResponse
+	}
+	if err := b.db.SetConfig(config); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		return
+	}
+	saved, err := b.db.GetConfig(method, endpoint, source)
+	if err != nil || saved == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload saved config"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"config": saved})
+}
@@ -0,0 +1,58 @@
+package broker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+func TestCreateConfigFromUnmatchedMustCoverSample(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantKey    [3]string // 创建的配置的 (method, endpoint, source)
+	}{
+		{"defaults to the sample", "", http.StatusOK, [3]string{"GET", "/orders/1", "device-a"}},
+		{"any method and device", `{"method": "", "source": ""}`, http.StatusOK, [3]string{"", "/orders/1", ""}},
+		{"template endpoint", `{"endpoint": "/orders/{id}"}`, http.StatusOK, [3]string{"GET", "/orders/{id}", "device-a"}},
+		{"other method", `{"method": "post"}`, http.StatusBadRequest, [3]string{}},
+		{"other source", `{"source": "device-b"}`, http.StatusBadRequest, [3]string{}},
+		{"other endpoint", `{"endpoint": "/users/{id}"}`, http.StatusBadRequest, [3]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBroker(t)
+			sample := &storage.Event{RequestID: "sample", Method: "GET", Endpoint: "/orders/1", Source: "device-a", Unmatched: true, Status: "Auto-Responded"}
+			if err := b.db.CreateEvent(sample); err != nil {
+				t.Fatalf("CreateEvent: %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/unmatched/sample/config", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Params = gin.Params{{Key: "requestId", Value: "sample"}}
+			b.HandleCreateConfigFromUnmatched(c)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			config, err := b.db.GetConfig(tt.wantKey[0], tt.wantKey[1], tt.wantKey[2])
+			if err != nil || config == nil {
+				t.Fatalf("config %v not saved: %v", tt.wantKey, err)
+			}
+			if covering, _, _ := b.db.GetConfigForRequest(sample.Method, sample.Endpoint, sample.Source); covering == nil || covering.ID != config.ID {
+				t.Fatalf("saved config does not cover the sample")
+			}
+		})
+	}
+}
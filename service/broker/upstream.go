@@ -0,0 +1,209 @@
+package broker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// hopHeaders 是转发时不应复制的逐跳头以及节点间内部使用的头。
+var hopHeaders = map[string]bool{
+	"Connection":              true,
+	"Keep-Alive":              true,
+	"Proxy-Authenticate":      true,
+	"Proxy-Authorization":     true,
+	"Te":                      true,
+	"Trailer":                 true,
+	"Transfer-Encoding":       true,
+	"Upgrade":                 true,
+	"Content-Length":          true,
+	"X-Forwarded-For-Service": true,
+}
+
+// recordedHeaders 返回记入历史的请求头，不含节点间内部使用的头。
+func recordedHeaders(header http.Header) map[string][]string {
+	out := make(map[string][]string, len(header))
+	for k, vs := range header {
+		if k != "X-Forwarded-For-Service" && k != "X-Forwarded-For" {
+			out[k] = vs
+		}
+	}
+	return out
+}
+
+// proxyToUpstream 将未命中配置的请求转发到上游服务，把真实响应返回给调用方并记入历史；
+// 上游开启 AutoCreateConfig 时用录制到的响应创建配置。
+func (b *EventBroker) proxyToUpstream(c *gin.Context, upstream *storage.Upstream, event *storage.Event, start time.Time) {
+	method, endpoint := c.Request.Method, c.Request.URL.Path
+	target := strings.TrimSuffix(upstream.URL, "/") + c.Request.URL.RequestURI()
+
+	proxyReq, err := http.NewRequest(method, target, bytes.NewReader([]byte(event.Payload)))
+	if err != nil {
+		b.failProxy(c, event, err, start)
+		return
+	}
+	// 不转发调用方的 Accept-Encoding，由 http.Transport 自行协商压缩并解压，
+	// 历史记录与录制的配置中保存的总是明文响应体
+	for k, vs := range c.Request.Header {
+		if !hopHeaders[k] && k != "Accept-Encoding" {
+			proxyReq.Header[k] = vs
+		}
+	}
+
+	resp, err := b.httpClient.Do(proxyReq)
+	if err != nil {
+		b.failProxy(c, event, err, start)
+		return
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		b.failProxy(c, event, err, start)
+		return
+	}
+
+	log.Printf("broker [primary]: Proxied %s %s to %s (%d).", method, endpoint, target, resp.StatusCode)
+	for k, vs := range resp.Header {
+		if hopHeaders[k] || k == "Content-Type" {
+			continue
+		}
+		for _, v := range vs {
+			c.Writer.Header().Add(k, v)
+		}
+	}
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
+
+	event.ResponseBody = string(respBody)
+	event.Status = "Proxied"
+	recordResponse(c, event, start)
+	if err := b.db.CreateEvent(event); err != nil {
+		log.Printf("broker: Failed to save proxied event: %v", err)
+	}
+	if upstream.AutoCreateConfig {
+		// 上游未经协商仍返回了压缩的响应体时，原样转给调用方但不用它创建配置
+		if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+			log.Printf("broker [primary]: Not recording %s %s: upstream response is %s encoded.", method, endpoint, encoding)
+			return
+		}
+		b.recordConfig(upstream, method, endpoint, string(respBody), resp)
+	}
+}
+
+func (b *EventBroker) failProxy(c *gin.Context, event *storage.Event, err error, start time.Time) {
+	log.Printf("broker [primary]: Failed to proxy %s to upstream: %v", event.Endpoint, err)
+	c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to proxy request to upstream: " + err.Error()})
+	event.Status = "Proxy Error: " + err.Error()
+	recordResponse(c, event, start)
+	b.db.CreateEvent(event)
+}
+
+// recordConfig 以录制到的响应为默认响应创建配置，配置已存在时不覆盖。
+func (b *EventBroker) recordConfig(upstream *storage.Upstream, method, endpoint, respBody string, resp *http.Response) {
+	existing, err := b.db.GetConfig(method, endpoint, upstream.Source)
+	if err != nil || existing != nil {
+		return
+	}
+	config := &storage.Config{
+		Method:          method,
+		Endpoint:        endpoint,
+		Project:         upstream.Project,
+		Remark:          "Recorded from " + upstream.URL,
+		DefaultResponse: respBody,
+		Source:          upstream.Source,
+		ResponseSpec: storage.ResponseSpec{
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+		},
+	}
+	if err := b.db.SetConfig(config); err != nil {
+		log.Printf("broker: Failed to create config from recorded response for %s %s: %v", method, endpoint, err)
+		return
+	}
+	log.Printf("broker [primary]: Created config for %s %s from recorded response.", method, endpoint)
+}
+
+// validateUpstream 检查上游地址与路径前缀是否合法。
+func validateUpstream(upstream *storage.Upstream) error {
+	u, err := url.Parse(upstream.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("url must not contain a query or fragment")
+	}
+	if upstream.PathPrefix != "" && !strings.HasPrefix(upstream.PathPrefix, "/") {
+		return fmt.Errorf("pathPrefix must start with '/'")
+	}
+	return nil
+}
+
+// HandleGetUpstreams 返回所有上游服务。
+func (b *EventBroker) HandleGetUpstreams(c *gin.Context) {
+	upstreams, err := b.db.GetAllUpstreams()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve upstreams"})
+		return
+	}
+	c.JSON(http.StatusOK, upstreams)
+}
+
+// HandleSetUpstream 创建上游服务，请求体带 id 时更新已有的上游服务。
+func (b *EventBroker) HandleSetUpstream(c *gin.Context) {
+	var req struct {
+		ID               uint   `json:"id"`
+		Project          string `json:"project"`
+		Source           string `json:"source"`
+		PathPrefix       string `json:"pathPrefix"`
+		URL              string `json:"url"`
+		AutoCreateConfig bool   `json:"autoCreateConfig"`
+		Remark           string `json:"remark"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+	upstream := &storage.Upstream{
+		Project:          req.Project,
+		Source:           req.Source,
+		PathPrefix:       req.PathPrefix,
+		URL:              req.URL,
+		AutoCreateConfig: req.AutoCreateConfig,
+		Remark:           req.Remark,
+	}
+	upstream.ID = req.ID
+	if err := validateUpstream(upstream); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upstream: " + err.Error()})
+		return
+	}
+	if err := b.db.SaveUpstream(upstream); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save upstream: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, upstream)
+}
+
+// HandleDeleteUpstream 删除上游服务。
+func (b *EventBroker) HandleDeleteUpstream(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upstream ID"})
+		return
+	}
+	if err := b.db.DeleteUpstream(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete upstream"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Upstream deleted successfully"})
+}
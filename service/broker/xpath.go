@@ -0,0 +1,315 @@
+package broker
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// xmlNode 是解析后的 XML 元素，Name.Space 为命名空间 URI（由 encoding/xml 解析前缀得到）。
+type xmlNode struct {
+	Name     xml.Name
+	Attrs    []xml.Attr
+	Children []*xmlNode
+	order    int             // 在文档中的先后顺序
+	text     strings.Builder // 元素及其后代的全部文本
+	own      strings.Builder // 元素自身的文本节点，不含子元素的文本
+	hasText  bool
+}
+
+// parseXMLDocument 将请求体解析为一棵元素树，返回一个虚拟的文档根节点。
+func parseXMLDocument(body string) (*xmlNode, error) {
+	dec := xml.NewDecoder(strings.NewReader(body))
+	dec.Strict = false
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+	order := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			order++
+			n := &xmlNode{Name: t.Name, Attrs: t.Attr, order: order}
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) == 1 {
+				continue
+			}
+			for _, n := range stack[1:] {
+				n.text.Write(t)
+			}
+			top := stack[len(stack)-1]
+			top.own.Write(t)
+			top.hasText = true
+		}
+	}
+	if len(root.Children) == 0 {
+		return nil, fmt.Errorf("no root element")
+	}
+	return root, nil
+}
+
+// stringValue 返回元素及其后代的全部文本。
+func (n *xmlNode) stringValue() string {
+	return n.text.String()
+}
+
+func (n *xmlNode) descendants() []*xmlNode {
+	var out []*xmlNode
+	for _, c := range n.Children {
+		out = append(out, c)
+		out = append(out, c.descendants()...)
+	}
+	return out
+}
+
+// selfAndDescendants 返回 nodes 及其所有后代，去重并按文档顺序排列，即 // 之后的步骤作用的元素。
+func selfAndDescendants(nodes []*xmlNode) []*xmlNode {
+	var out []*xmlNode
+	for _, n := range nodes {
+		out = append(out, n)
+		out = append(out, n.descendants()...)
+	}
+	return inDocumentOrder(out)
+}
+
+// inDocumentOrder 去掉重复的元素并按文档顺序排列。
+func inDocumentOrder(nodes []*xmlNode) []*xmlNode {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].order < nodes[j].order })
+	out := nodes[:0]
+	for i, n := range nodes {
+		if i == 0 || n != nodes[i-1] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// xpathName 是解析后的限定名。space 为空且 anySpace 为真时只按本地名匹配。
+type xpathName struct {
+	space    string
+	local    string
+	anySpace bool
+}
+
+func (q xpathName) matches(name xml.Name) bool {
+	if q.local != "*" && q.local != name.Local {
+		return false
+	}
+	return q.anySpace || q.space == name.Space
+}
+
+type xpathStep struct {
+	descendant bool // 以 // 引入
+	attr       bool
+	text       bool
+	name       xpathName
+	position   int // [n]，从 1 开始，0 表示无
+	predAttr   *xpathName
+	predValue  string
+}
+
+type xpathExpr struct {
+	steps []xpathStep
+}
+
+// parseXPath 解析 XPath 的常用子集：绝对路径、//、*、prefix:name、@attr、text()、[n]、[@attr='v']。
+// 带前缀的名称按 namespaces 中的 URI 严格匹配；不带前缀的名称只比较本地名，以便直接匹配默认命名空间下的 SOAP 报文。
+func parseXPath(expr string, namespaces map[string]string) (*xpathExpr, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "/") {
+		return nil, fmt.Errorf("XPath must be absolute (start with '/')")
+	}
+	var steps []xpathStep
+	i := 0
+	for i < len(expr) {
+		var step xpathStep
+		if strings.HasPrefix(expr[i:], "//") {
+			step.descendant = true
+			i += 2
+		} else if expr[i] == '/' {
+			i++
+		} else {
+			return nil, fmt.Errorf("expected '/' at position %d", i)
+		}
+		start := i
+		depth := 0
+		for i < len(expr) && (depth > 0 || expr[i] != '/') {
+			switch expr[i] {
+			case '[':
+				depth++
+			case ']':
+				depth--
+			}
+			i++
+		}
+		if depth != 0 {
+			return nil, fmt.Errorf("unbalanced '[' in step %q", expr[start:i])
+		}
+		if err := parseXPathStep(expr[start:i], namespaces, &step); err != nil {
+			return nil, err
+		}
+		if len(steps) > 0 {
+			if prev := steps[len(steps)-1]; prev.attr || prev.text {
+				return nil, fmt.Errorf("%q must be the last step", "@"+prev.name.local)
+			}
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty XPath")
+	}
+	return &xpathExpr{steps: steps}, nil
+}
+
+func parseXPathStep(raw string, namespaces map[string]string, step *xpathStep) error {
+	if raw == "" {
+		return fmt.Errorf("empty step")
+	}
+	namepart := raw
+	if idx := strings.IndexByte(raw, '['); idx >= 0 {
+		if !strings.HasSuffix(raw, "]") {
+			return fmt.Errorf("invalid predicate in step %q", raw)
+		}
+		namepart = raw[:idx]
+		if err := parseXPathPredicate(raw[idx+1:len(raw)-1], namespaces, step); err != nil {
+			return err
+		}
+	}
+	switch {
+	case namepart == "text()":
+		step.text = true
+		return nil
+	case strings.HasPrefix(namepart, "@"):
+		step.attr = true
+		namepart = namepart[1:]
+	}
+	name, err := parseXPathName(namepart, namespaces)
+	if err != nil {
+		return err
+	}
+	step.name = name
+	return nil
+}
+
+func parseXPathPredicate(pred string, namespaces map[string]string, step *xpathStep) error {
+	pred = strings.TrimSpace(pred)
+	if n, err := strconv.Atoi(pred); err == nil {
+		if n < 1 {
+			return fmt.Errorf("position predicate must be >= 1")
+		}
+		step.position = n
+		return nil
+	}
+	eq := strings.IndexByte(pred, '=')
+	if !strings.HasPrefix(pred, "@") || eq < 0 {
+		return fmt.Errorf("unsupported predicate [%s]", pred)
+	}
+	name, err := parseXPathName(strings.TrimSpace(pred[1:eq]), namespaces)
+	if err != nil {
+		return err
+	}
+	value := strings.TrimSpace(pred[eq+1:])
+	if len(value) < 2 || (value[0] != '\'' && value[0] != '"') || value[len(value)-1] != value[0] {
+		return fmt.Errorf("predicate value must be quoted in [%s]", pred)
+	}
+	step.predAttr = &name
+	step.predValue = value[1 : len(value)-1]
+	return nil
+}
+
+func parseXPathName(raw string, namespaces map[string]string) (xpathName, error) {
+	if raw == "" {
+		return xpathName{}, fmt.Errorf("empty name")
+	}
+	prefix, local, hasPrefix := strings.Cut(raw, ":")
+	if !hasPrefix {
+		return xpathName{local: raw, anySpace: true}, nil
+	}
+	uri, ok := namespaces[prefix]
+	if !ok {
+		return xpathName{}, fmt.Errorf("undeclared namespace prefix %q", prefix)
+	}
+	if local == "" {
+		return xpathName{}, fmt.Errorf("empty local name in %q", raw)
+	}
+	return xpathName{space: uri, local: local}, nil
+}
+
+// eval 返回表达式在文档中命中的所有值：元素取全部文本，text() 取元素自身的文本，属性取属性值。
+// [n] 在每个父元素的子元素中计数，//x[n] 即各父元素下的第 n 个 x。
+func (x *xpathExpr) eval(root *xmlNode) []string {
+	nodes := []*xmlNode{root}
+	for _, step := range x.steps {
+		context := nodes
+		if step.descendant {
+			context = selfAndDescendants(nodes)
+		}
+		if step.attr || step.text {
+			var out []string
+			for _, n := range context {
+				if step.text {
+					if n.hasText {
+						out = append(out, n.own.String())
+					}
+					continue
+				}
+				for _, a := range n.Attrs {
+					if step.name.matches(a.Name) {
+						out = append(out, a.Value)
+					}
+				}
+			}
+			return out
+		}
+		var next []*xmlNode
+		for _, n := range context {
+			var matched []*xmlNode
+			for _, c := range n.Children {
+				if step.name.matches(c.Name) && step.predicateMatches(c) {
+					matched = append(matched, c)
+				}
+			}
+			if step.position > 0 {
+				if step.position <= len(matched) {
+					next = append(next, matched[step.position-1])
+				}
+				continue
+			}
+			next = append(next, matched...)
+		}
+		nodes = inDocumentOrder(next)
+	}
+	out := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		out = append(out, n.stringValue())
+	}
+	return out
+}
+
+func (s xpathStep) predicateMatches(n *xmlNode) bool {
+	if s.predAttr == nil {
+		return true
+	}
+	for _, a := range n.Attrs {
+		if s.predAttr.matches(a.Name) && a.Value == s.predValue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package broker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestXPathEval(t *testing.T) {
+	const doc = `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:o="urn:orders">
+  <soap:Header><o:Token>abc</o:Token></soap:Header>
+  <soap:Body>body text<o:CreateOrder id="7" type="rush">
+      <o:Item sku="a-1">first</o:Item>
+      <o:Item sku="b-2">second</o:Item>
+    </o:CreateOrder>
+    <o:CreateOrder id="8">
+      <o:Item sku="c-3">third</o:Item>
+      <o:Group><o:Item sku="d-4">nested</o:Item></o:Group>
+    </o:CreateOrder>
+  </soap:Body>
+</soap:Envelope>`
+	namespaces := map[string]string{
+		"s": "http://schemas.xmlsoap.org/soap/envelope/",
+		"o": "urn:orders",
+		"x": "urn:other",
+	}
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"/Envelope/Header/Token", []string{"abc"}},
+		{"/s:Envelope/s:Header/o:Token", []string{"abc"}},
+		{"/s:Envelope/s:Header/x:Token", nil},
+		{"/Envelope/Body/text()", []string{"body text"}},
+		{"/Envelope/Body/CreateOrder/Item/text()", []string{"first", "second", "third"}},
+		{"/Envelope/Header/text()", nil},
+		{"//CreateOrder/@id", []string{"7", "8"}},
+		{"//CreateOrder[@type='rush']/@id", []string{"7"}},
+		{`//o:CreateOrder[@id="8"]/Item`, []string{"third"}},
+		{"//CreateOrder[2]/@id", []string{"8"}},
+		{"//Item[1]/@sku", []string{"a-1", "c-3", "d-4"}},
+		{"//Item[2]/@sku", []string{"b-2"}},
+		{"/Envelope/Body/CreateOrder[1]/Item[2]", []string{"second"}},
+		{"//Body//Item/@sku", []string{"a-1", "b-2", "c-3", "d-4"}},
+		{"//CreateOrder//Item/@sku", []string{"a-1", "b-2", "c-3", "d-4"}},
+		{"//*//Item/@sku", []string{"a-1", "b-2", "c-3", "d-4"}},
+		{"/Envelope/*/Token", []string{"abc"}},
+		{"//@sku", []string{"a-1", "b-2", "c-3", "d-4"}},
+	}
+	root, err := parseXMLDocument(doc)
+	if err != nil {
+		t.Fatalf("parseXMLDocument: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			x, err := parseXPath(tt.expr, namespaces)
+			if err != nil {
+				t.Fatalf("parseXPath: %v", err)
+			}
+			var got []string
+			for _, v := range x.eval(root) {
+				got = append(got, strings.TrimSpace(v))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseXPathErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"Envelope/Body",
+		"/Envelope//",
+		"/Envelope/Body[",
+		"/Envelope/Body[0]",
+		"/Envelope/Body[@id=7]",
+		"/Envelope/Body[name()='x']",
+		"/Envelope/@id/Body",
+		"/Envelope/text()/Body",
+		"/soap:Envelope",
+		"/o:",
+	} {
+		if _, err := parseXPath(expr, map[string]string{"o": "urn:orders"}); err == nil {
+			t.Errorf("parseXPath(%q) succeeded, want error", expr)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package pending
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"mock.com/zyuc-mock-clean/service/bus"
+	"mock.com/zyuc-mock-clean/storage"
+)
+
+// Request types, matching the "type" field of the SSE payload.
+const (
+	TypeHTTP = "http"
+	TypeSSH  = "ssh"
+)
+
+// Events published on the bus when a pending request changes state.
+const (
+	EventResolved  = "pending.resolved"
+	EventExtended  = "pending.extended"
+	EventClaimed   = "pending.claimed"
+	EventUnclaimed = "pending.unclaimed"
+)
+
+var (
+	// ErrNotFound is returned when no held request has the given ID.
+	ErrNotFound = errors.New("request not found or already processed")
+	// ErrAlreadyAnswered is returned when a reply is already on its way to the request.
+	ErrAlreadyAnswered = errors.New("request has already been answered")
+	// ErrHeldIndefinitely is returned when extending a request that has no deadline.
+	ErrHeldIndefinitely = errors.New("request is held indefinitely")
+	// ErrNotClaimed is returned when releasing a claim that the operator does not hold.
+	ErrNotClaimed = errors.New("request is not claimed by this operator")
+)
+
+// ClaimedError is returned when an operator acts on a request claimed by someone else.
+type ClaimedError struct {
+	Operator string
+}
+
+func (e *ClaimedError) Error() string {
+	return fmt.Sprintf("request is claimed by %s", e.Operator)
+}
+
+// Response is the reply delivered to a held request. HTTP requests use everything
+// but Stderr and ExitCode; SSH commands use Body as stdout, Stderr and ExitCode.
+type Response struct {
+	StatusCode  int               `json:"statusCode"`
+	ContentType string            `json:"contentType"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body"`
+	Stderr      string            `json:"stderr,omitempty"`
+	ExitCode    int               `json:"exitCode,omitempty"`
+}
+
+// Reply is a response together with the status recorded in the history.
+type Reply struct {
+	Response Response
+	Status   string
+}
+
+// Request is an HTTP call or SSH command held while waiting for an operator reply.
+type Request struct {
+	ID              string
+	Type            string
+	Method          string
+	Endpoint        string // the HTTP path or the SSH command
+	PathParams      map[string]string
+	Payload         string
+	Project         string
+	Source          string
+	DefaultResponse Response
+	// TimeoutStatus is recorded in the history when the hold expires.
+	TimeoutStatus string
+
+	createdAt time.Time
+	expiresAt time.Time // zero when held indefinitely
+	claimedBy string
+	claimedAt time.Time
+	timer     *time.Timer
+	answered  bool // set once a reply is delivered, even after it has been received
+	replies   chan Reply
+}
+
+// Replies returns the channel the reply is delivered on. At most one reply is
+// ever sent, including the default response when the hold expires.
+func (r *Request) Replies() <-chan Reply {
+	return r.replies
+}
+
+// Info is the JSON view of a pending request. It is both the SSE payload
+// announcing the request and an entry of the pending list.
+type Info struct {
+	RequestID          string            `json:"requestId"`
+	Type               string            `json:"type"`
+	Method             string            `json:"method,omitempty"`
+	Endpoint           string            `json:"endpoint,omitempty"`
+	Command            string            `json:"command,omitempty"`
+	PathParams         map[string]string `json:"pathParams,omitempty"`
+	Payload            string            `json:"payload"`
+	Project            string            `json:"project"`
+	Source             string            `json:"source"`
+	DefaultResponse    string            `json:"defaultResponse"`
+	DefaultStatusCode  int               `json:"defaultStatusCode,omitempty"`
+	DefaultContentType string            `json:"defaultContentType,omitempty"`
+	DefaultHeaders     map[string]string `json:"defaultHeaders,omitempty"`
+	DefaultStderr      string            `json:"defaultStderr,omitempty"`
+	DefaultExitCode    int               `json:"defaultExitCode,omitempty"`
+	HoldTimeout        int               `json:"holdTimeout"` // seconds, -1 when held indefinitely
+	ExpiresAt          int64             `json:"expiresAt"`   // unix milliseconds, 0 when held indefinitely
+	CreatedAt          int64             `json:"createdAt"`   // unix milliseconds
+	Age                int               `json:"age"`         // seconds since the request arrived
+	ClaimedBy          string            `json:"claimedBy,omitempty"`
+	ClaimedAt          int64             `json:"claimedAt,omitempty"` // unix milliseconds
+}
+
+// Update is published on the bus when a pending request is resolved or its hold is extended.
+type Update struct {
+	Event       string `json:"event"`
+	RequestID   string `json:"requestId"`
+	Status      string `json:"status,omitempty"`
+	Operator    string `json:"operator,omitempty"`
+	HoldTimeout int    `json:"holdTimeout,omitempty"`
+	ExpiresAt   int64  `json:"expiresAt,omitempty"`
+}
+
+// Registry holds the pending requests of every protocol so that a single
+// respond API can answer any of them.
+type Registry struct {
+	bus      *bus.JsonEventBus
+	requests map[string]*Request
+	mu       sync.Mutex
+}
+
+// New creates an empty Registry that announces state changes on the given bus.
+func New(bus *bus.JsonEventBus) *Registry {
+	return &Registry{
+		bus:      bus,
+		requests: make(map[string]*Request),
+	}
+}
+
+// Add registers a request held for the given duration and announces it on the
+// bus; a negative hold waits until the request is answered. When the hold
+// expires the default response is delivered with TimeoutStatus. The caller must
+// Remove the request once it stops waiting.
+func (r *Registry) Add(req *Request, hold time.Duration) {
+	req.replies = make(chan Reply, 1)
+	req.createdAt = time.Now()
+	if hold >= 0 {
+		req.expiresAt = req.createdAt.Add(hold)
+	}
+	// Arm the timer before the request becomes visible so that Extend and
+	// Remove never see a held request without it.
+	r.mu.Lock()
+	if hold >= 0 {
+		req.timer = time.AfterFunc(hold, func() {
+			r.deliver(req, Reply{Response: req.DefaultResponse, Status: req.TimeoutStatus}, "")
+		})
+	}
+	r.requests[req.ID] = req
+	info := req.info()
+	r.mu.Unlock()
+
+	if r.bus != nil {
+		msg, _ := json.Marshal(info)
+		r.bus.Publish(string(msg))
+	}
+}
+
+// Remove unregisters a request.
+func (r *Registry) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req, ok := r.requests[id]; ok {
+		if req.timer != nil {
+			req.timer.Stop()
+		}
+		delete(r.requests, id)
+	}
+}
+
+// Get returns the pending request with the given ID.
+func (r *Registry) Get(id string) (*Request, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok := r.requests[id]
+	return req, ok
+}
+
+// Len returns the number of pending requests.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+// Info returns the JSON view of a request.
+func (r *Registry) Info(req *Request) Info {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return req.info()
+}
+
+// List returns every pending request, oldest first.
+func (r *Registry) List() []Info {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Info, 0, len(r.requests))
+	for _, req := range r.requests {
+		out = append(out, req.info())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt < out[j].CreatedAt })
+	return out
+}
+
+// Claim locks the request for the operator so that only they can answer it.
+// Claiming a request the operator already holds is a no-op.
+func (r *Registry) Claim(id, operator string) (Info, error) {
+	r.mu.Lock()
+	req, ok := r.requests[id]
+	if !ok {
+		r.mu.Unlock()
+		return Info{}, ErrNotFound
+	}
+	if req.answered {
+		r.mu.Unlock()
+		return Info{}, ErrAlreadyAnswered
+	}
+	if req.claimedBy != "" && req.claimedBy != operator {
+		r.mu.Unlock()
+		return Info{}, &ClaimedError{Operator: req.claimedBy}
+	}
+	changed := req.claimedBy == ""
+	if changed {
+		req.claimedBy = operator
+		req.claimedAt = time.Now()
+	}
+	info := req.info()
+	r.mu.Unlock()
+
+	if changed {
+		r.publish(Update{Event: EventClaimed, RequestID: id, Operator: operator})
+	}
+	return info, nil
+}
+
+// Unclaim releases the operator's lock on the request.
+func (r *Registry) Unclaim(id, operator string) error {
+	r.mu.Lock()
+	req, ok := r.requests[id]
+	if !ok {
+		r.mu.Unlock()
+		return ErrNotFound
+	}
+	if req.claimedBy != operator {
+		r.mu.Unlock()
+		return ErrNotClaimed
+	}
+	req.claimedBy = ""
+	req.claimedAt = time.Time{}
+	r.mu.Unlock()
+
+	r.publish(Update{Event: EventUnclaimed, RequestID: id, Operator: operator})
+	return nil
+}
+
+// Respond delivers a reply to the request with the given ID on behalf of the
+// operator. Requests claimed by another operator are refused.
+func (r *Registry) Respond(id, operator string, reply Reply) error {
+	req, err := r.lookup(id, operator)
+	if err != nil {
+		return err
+	}
+	if !r.deliver(req, reply, operator) {
+		return ErrAlreadyAnswered
+	}
+	return nil
+}
+
+// Release answers the request with its default response on behalf of the operator.
+func (r *Registry) Release(id, operator, status string) error {
+	req, err := r.lookup(id, operator)
+	if err != nil {
+		return err
+	}
+	if !r.deliver(req, Reply{Response: req.DefaultResponse, Status: status}, operator) {
+		return ErrAlreadyAnswered
+	}
+	return nil
+}
+
+// lookup returns the request if the operator may act on it.
+func (r *Registry) lookup(id, operator string) (*Request, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok := r.requests[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if req.claimedBy != "" && req.claimedBy != operator {
+		return nil, &ClaimedError{Operator: req.claimedBy}
+	}
+	return req, nil
+}
+
+// ReleaseAll answers every pending request the operator may act on with its
+// default response. It returns the IDs of the requests that were released and
+// of those skipped because another operator has claimed them.
+func (r *Registry) ReleaseAll(operator, status string) (released, skipped []string) {
+	for _, req := range r.snapshot() {
+		r.mu.Lock()
+		claimedBy := req.claimedBy
+		r.mu.Unlock()
+		if claimedBy != "" && claimedBy != operator {
+			skipped = append(skipped, req.ID)
+			continue
+		}
+		if r.deliver(req, Reply{Response: req.DefaultResponse, Status: status}, operator) {
+			released = append(released, req.ID)
+		}
+	}
+	return released, skipped
+}
+
+// Drain answers every pending request with its default response, claimed or
+// not, and returns the IDs of the requests that were released. It is meant for
+// when no operator is left to answer them.
+func (r *Registry) Drain(status string) []string {
+	var released []string
+	for _, req := range r.snapshot() {
+		if r.deliver(req, Reply{Response: req.DefaultResponse, Status: status}, "") {
+			released = append(released, req.ID)
+		}
+	}
+	return released
+}
+
+// snapshot returns the pending requests at the time of the call.
+func (r *Registry) snapshot() []*Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	requests := make([]*Request, 0, len(r.requests))
+	for _, req := range r.requests {
+		requests = append(requests, req)
+	}
+	return requests
+}
+
+// Extend pushes the deadline of the request back by d on behalf of the operator.
+func (r *Registry) Extend(id, operator string, d time.Duration) (Info, error) {
+	r.mu.Lock()
+	req, ok := r.requests[id]
+	if !ok {
+		r.mu.Unlock()
+		return Info{}, ErrNotFound
+	}
+	if req.claimedBy != "" && req.claimedBy != operator {
+		r.mu.Unlock()
+		return Info{}, &ClaimedError{Operator: req.claimedBy}
+	}
+	if req.timer == nil {
+		r.mu.Unlock()
+		return Info{}, ErrHeldIndefinitely
+	}
+	if req.answered || !req.timer.Stop() {
+		r.mu.Unlock()
+		return Info{}, ErrAlreadyAnswered
+	}
+	req.expiresAt = req.expiresAt.Add(d)
+	req.timer.Reset(time.Until(req.expiresAt))
+	info := req.info()
+	r.mu.Unlock()
+
+	r.publish(Update{Event: EventExtended, RequestID: id, HoldTimeout: info.HoldTimeout, ExpiresAt: info.ExpiresAt})
+	return info, nil
+}
+
+// deliver hands the reply to the waiting request unless one was already delivered.
+// operator is empty when the reply was not sent by an operator.
+func (r *Registry) deliver(req *Request, reply Reply, operator string) bool {
+	r.mu.Lock()
+	if req.answered {
+		r.mu.Unlock()
+		return false
+	}
+	req.answered = true
+	r.mu.Unlock()
+	req.replies <- reply
+	r.publish(Update{Event: EventResolved, RequestID: req.ID, Status: reply.Status, Operator: operator})
+	return true
+}
+
+func (r *Registry) publish(u Update) {
+	if r.bus == nil {
+		return
+	}
+	msg, _ := json.Marshal(u)
+	r.bus.Publish(string(msg))
+}
+
+func (r *Request) info() Info {
+	info := Info{
+		RequestID:       r.ID,
+		Type:            r.Type,
+		Method:          r.Method,
+		PathParams:      r.PathParams,
+		Payload:         r.Payload,
+		Project:         r.Project,
+		Source:          r.Source,
+		DefaultResponse: r.DefaultResponse.Body,
+		HoldTimeout:     storage.HoldIndefinitely,
+		CreatedAt:       r.createdAt.UnixMilli(),
+		Age:             int(time.Since(r.createdAt) / time.Second),
+	}
+	if r.Type == TypeSSH {
+		info.Command = r.Endpoint
+		info.DefaultStderr = r.DefaultResponse.Stderr
+		info.DefaultExitCode = r.DefaultResponse.ExitCode
+	} else {
+		info.Endpoint = r.Endpoint
+		info.DefaultStatusCode = r.DefaultResponse.StatusCode
+		info.DefaultContentType = r.DefaultResponse.ContentType
+		info.DefaultHeaders = r.DefaultResponse.Headers
+	}
+	if r.claimedBy != "" {
+		info.ClaimedBy = r.claimedBy
+		info.ClaimedAt = r.claimedAt.UnixMilli()
+	}
+	if !r.expiresAt.IsZero() {
+		info.HoldTimeout = int(r.expiresAt.Sub(r.createdAt) / time.Second)
+		info.ExpiresAt = r.expiresAt.UnixMilli()
+	}
+	return info
+}
@@ -0,0 +1,195 @@
+package pending
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+func addRequest(t *testing.T, r *Registry, id string, hold time.Duration) *Request {
+	t.Helper()
+	req := &Request{ID: id, Type: TypeHTTP, DefaultResponse: Response{StatusCode: 200, Body: "default " + id}, TimeoutStatus: "Auto-Responded"}
+	r.Add(req, hold)
+	t.Cleanup(func() { r.Remove(id) })
+	return req
+}
+
+func receive(t *testing.T, req *Request) Reply {
+	t.Helper()
+	select {
+	case reply := <-req.Replies():
+		return reply
+	case <-time.After(time.Second):
+		t.Fatalf("no reply delivered to %s", req.ID)
+		return Reply{}
+	}
+}
+
+func TestClaimedRequestRefusesOtherOperators(t *testing.T) {
+	tests := []struct {
+		name string
+		act  func(r *Registry, operator string) error
+	}{
+		{"respond", func(r *Registry, operator string) error {
+			return r.Respond("req", operator, Reply{Status: "Responded"})
+		}},
+		{"release", func(r *Registry, operator string) error {
+			return r.Release("req", operator, "Released")
+		}},
+		{"extend", func(r *Registry, operator string) error {
+			_, err := r.Extend("req", operator, time.Minute)
+			return err
+		}},
+		{"claim", func(r *Registry, operator string) error {
+			_, err := r.Claim("req", operator)
+			return err
+		}},
+		{"unclaim", func(r *Registry, operator string) error {
+			return r.Unclaim("req", operator)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(nil)
+			addRequest(t, r, "req", time.Hour)
+			if _, err := r.Claim("req", "alice"); err != nil {
+				t.Fatalf("Claim: %v", err)
+			}
+
+			err := tt.act(r, "bob")
+			var claimed *ClaimedError
+			if tt.name == "unclaim" {
+				if !errors.Is(err, ErrNotClaimed) {
+					t.Fatalf("bob: got %v, want ErrNotClaimed", err)
+				}
+			} else if !errors.As(err, &claimed) || claimed.Operator != "alice" {
+				t.Fatalf("bob: got %v, want a claim by alice", err)
+			}
+			if err := tt.act(r, "alice"); err != nil {
+				t.Fatalf("alice: %v", err)
+			}
+		})
+	}
+}
+
+func TestRespondDeliversOnce(t *testing.T) {
+	r := New(nil)
+	req := addRequest(t, r, "req", time.Hour)
+
+	if err := r.Respond("req", "alice", Reply{Response: Response{Body: "first"}, Status: "Responded"}); err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+	if err := r.Respond("req", "alice", Reply{Response: Response{Body: "second"}}); !errors.Is(err, ErrAlreadyAnswered) {
+		t.Fatalf("second Respond: got %v, want ErrAlreadyAnswered", err)
+	}
+	if _, err := r.Claim("req", "bob"); !errors.Is(err, ErrAlreadyAnswered) {
+		t.Fatalf("Claim after reply: got %v, want ErrAlreadyAnswered", err)
+	}
+	if reply := receive(t, req); reply.Response.Body != "first" {
+		t.Fatalf("got %q, want the first reply", reply.Response.Body)
+	}
+	if err := r.Respond("missing", "alice", Reply{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown request: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestHoldExpiresWithDefaultResponse(t *testing.T) {
+	r := New(nil)
+	req := addRequest(t, r, "req", 10*time.Millisecond)
+	reply := receive(t, req)
+	if reply.Status != "Auto-Responded" || reply.Response.Body != "default req" {
+		t.Fatalf("got %+v, want the default response", reply)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	r := New(nil)
+	addRequest(t, r, "held", 50*time.Millisecond)
+	addRequest(t, r, "forever", -1)
+
+	info, err := r.Extend("held", "alice", time.Minute)
+	if err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	if info.HoldTimeout != 60 {
+		t.Fatalf("hold is %ds, want 60s", info.HoldTimeout)
+	}
+	if _, err := r.Extend("forever", "alice", time.Minute); !errors.Is(err, ErrHeldIndefinitely) {
+		t.Fatalf("indefinite hold: got %v, want ErrHeldIndefinitely", err)
+	}
+}
+
+func TestReleaseAllSkipsOtherOperatorsClaims(t *testing.T) {
+	r := New(nil)
+	free := addRequest(t, r, "free", -1)
+	mine := addRequest(t, r, "mine", -1)
+	theirs := addRequest(t, r, "theirs", -1)
+	if _, err := r.Claim("mine", "alice"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if _, err := r.Claim("theirs", "bob"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+
+	released, skipped := r.ReleaseAll("alice", "Released")
+	sort.Strings(released)
+	if len(released) != 2 || released[0] != "free" || released[1] != "mine" {
+		t.Fatalf("released %v, want [free mine]", released)
+	}
+	if len(skipped) != 1 || skipped[0] != "theirs" {
+		t.Fatalf("skipped %v, want [theirs]", skipped)
+	}
+	for _, req := range []*Request{free, mine} {
+		if reply := receive(t, req); reply.Status != "Released" {
+			t.Fatalf("%s: status %q, want Released", req.ID, reply.Status)
+		}
+	}
+	if len(theirs.Replies()) != 0 {
+		t.Fatalf("request claimed by bob was released")
+	}
+
+	drained := r.Drain("Auto-Responded (Disconnect)")
+	if len(drained) != 1 || drained[0] != "theirs" {
+		t.Fatalf("drained %v, want [theirs]", drained)
+	}
+	if reply := receive(t, theirs); reply.Status != "Auto-Responded (Disconnect)" {
+		t.Fatalf("status %q, want Auto-Responded (Disconnect)", reply.Status)
+	}
+}
+
+func TestListOldestFirst(t *testing.T) {
+	r := New(nil)
+	for _, id := range []string{"a", "b", "c"} {
+		addRequest(t, r, id, -1)
+		time.Sleep(2 * time.Millisecond)
+	}
+	r.Remove("b")
+	list := r.List()
+	if len(list) != 2 || list[0].RequestID != "a" || list[1].RequestID != "c" {
+		t.Fatalf("got %+v, want a then c", list)
+	}
+	if list[0].HoldTimeout != -1 || list[0].ExpiresAt != 0 {
+		t.Fatalf("indefinite hold reported as %ds expiring at %d", list[0].HoldTimeout, list[0].ExpiresAt)
+	}
+}
+
+func TestAddArmsTimerBeforeRegistering(t *testing.T) {
+	r := New(nil)
+	req := &Request{ID: "req", Type: TypeHTTP, TimeoutStatus: "Auto-Responded"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, ok := r.Get("req"); ok {
+				if _, err := r.Extend("req", "alice", time.Minute); errors.Is(err, ErrHeldIndefinitely) {
+					t.Errorf("Extend saw a held request without its timer")
+				}
+				return
+			}
+		}
+	}()
+	r.Add(req, time.Hour)
+	<-done
+	r.Remove("req")
+}
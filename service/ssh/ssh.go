@@ -1,48 +1,218 @@
 package ssh
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/ssh"
 	"mock.com/zyuc-mock-clean/service/bus"
+	"mock.com/zyuc-mock-clean/service/pending"
 	"mock.com/zyuc-mock-clean/storage"
 )
 
 // SSHServer holds the components for the SSH mock server.
 type SSHServer struct {
-	bus    *bus.JsonEventBus
-	db     *storage.DB
-	config *ssh.ServerConfig
+	bus         *bus.JsonEventBus
+	pending     *pending.Registry
+	db          *storage.DB
+	config      *ssh.ServerConfig
+	holdTimeout time.Duration
+	authMode    string
 }
 
-// NewSSHServer creates a new SSH server instance.
-func NewSSHServer(bus *bus.JsonEventBus, db *storage.DB, privateKey string) (*SSHServer, error) {
+// Authentication modes accepted by NewSSHServer.
+const (
+	// AuthAcceptAll lets any client in with any password, key or answers.
+	AuthAcceptAll = "accept-all"
+	// AuthUsers only admits the enabled users of the SSH user store.
+	AuthUsers = "users"
+)
+
+// projectExtension carries the project of the authenticated user in ssh.Permissions.
+const projectExtension = "project"
+
+// NewSSHServer creates a new SSH server instance. Held commands are registered in
+// the pending registry shared with the HTTP broker so /api/respond can answer them.
+// holdTimeout is the global time a command waits for an operator reply; a negative
+// value waits indefinitely. authMode is AuthAcceptAll or AuthUsers; in both modes a
+// user found in the store records its commands under the user's project.
+func NewSSHServer(bus *bus.JsonEventBus, pendingReqs *pending.Registry, db *storage.DB, privateKey string, holdTimeout time.Duration, authMode string) (*SSHServer, error) {
+	if authMode != AuthAcceptAll && authMode != AuthUsers {
+		return nil, fmt.Errorf("unknown SSH auth mode %q, expected %s or %s", authMode, AuthAcceptAll, AuthUsers)
+	}
 	privateBytes := []byte(privateKey)
 	private, err := ssh.ParsePrivateKey(privateBytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key: %w", err)
 	}
 
-	config := &ssh.ServerConfig{
-		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+	s := &SSHServer{
+		bus:         bus,
+		pending:     pendingReqs,
+		db:          db,
+		holdTimeout: holdTimeout,
+		authMode:    authMode,
+	}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback:            s.checkPassword,
+		PublicKeyCallback:           s.checkPublicKey,
+		KeyboardInteractiveCallback: s.checkKeyboardInteractive,
+	}
+	s.config.AddHostKey(private)
+	return s, nil
+}
+
+// lookupUser returns the stored user for a login attempt. In AuthUsers mode an
+// unknown or disabled user is an error; in AuthAcceptAll mode it is nil.
+func (s *SSHServer) lookupUser(c ssh.ConnMetadata) (*storage.SshUser, error) {
+	user, err := s.db.GetSshUser(c.User())
+	if err != nil {
+		log.Printf("Failed to look up SSH user %s: %v", c.User(), err)
+		if s.authMode == AuthAcceptAll {
 			return nil, nil
-		},
+		}
+		return nil, err
+	}
+	if s.authMode == AuthUsers && (user == nil || user.Disabled) {
+		return nil, fmt.Errorf("unknown or disabled user %s", c.User())
+	}
+	return user, nil
+}
+
+// permit builds the permissions of an authenticated connection.
+func permit(user *storage.SshUser) *ssh.Permissions {
+	if user == nil || user.Project == "" {
+		return &ssh.Permissions{}
+	}
+	return &ssh.Permissions{Extensions: map[string]string{projectExtension: user.Project}}
+}
+
+func (s *SSHServer) checkPassword(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+	user, err := s.lookupUser(c)
+	if err != nil {
+		return nil, authFailed(c, "password", err)
+	}
+	if s.authMode == AuthUsers && !user.CheckPassword(string(pass)) {
+		return nil, authFailed(c, "password", fmt.Errorf("wrong password"))
+	}
+	return permit(user), nil
+}
+
+func (s *SSHServer) checkPublicKey(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	user, err := s.lookupUser(c)
+	if err != nil {
+		return nil, authFailed(c, "publickey", err)
+	}
+	if s.authMode == AuthUsers && !isAuthorizedKey(user, key) {
+		return nil, authFailed(c, "publickey", fmt.Errorf("key %s is not authorized", ssh.FingerprintSHA256(key)))
 	}
-	config.AddHostKey(private)
+	return permit(user), nil
+}
 
-	return &SSHServer{
-		bus:    bus,
-		db:     db,
-		config: config,
-	}, nil
+// checkKeyboardInteractive asks for the user's password, if one is set, followed
+// by each of the user's challenges, and requires every answer to match. A user
+// with neither cannot log in this way.
+func (s *SSHServer) checkKeyboardInteractive(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	user, err := s.lookupUser(c)
+	if err != nil {
+		return nil, authFailed(c, "keyboard-interactive", err)
+	}
+	if s.authMode == AuthAcceptAll {
+		return permit(user), nil
+	}
+	if !user.HasPassword && len(user.Challenges) == 0 {
+		return nil, authFailed(c, "keyboard-interactive", fmt.Errorf("no password or challenges configured"))
+	}
+
+	var questions []string
+	var echos []bool
+	if user.HasPassword {
+		questions = append(questions, "Password: ")
+		echos = append(echos, false)
+	}
+	for _, ch := range user.Challenges {
+		questions = append(questions, ch.Prompt)
+		echos = append(echos, ch.Echo)
+	}
+	answers, err := client("", "", questions, echos)
+	if err != nil {
+		return nil, err
+	}
+	if len(answers) != len(questions) {
+		return nil, authFailed(c, "keyboard-interactive", fmt.Errorf("expected %d answers, got %d", len(questions), len(answers)))
+	}
+	if user.HasPassword {
+		if !user.CheckPassword(answers[0]) {
+			return nil, authFailed(c, "keyboard-interactive", fmt.Errorf("wrong password"))
+		}
+		answers = answers[1:]
+	}
+	for i, ch := range user.Challenges {
+		if !user.CheckChallengeAnswer(i, answers[i]) {
+			return nil, authFailed(c, "keyboard-interactive", fmt.Errorf("wrong answer to %q", ch.Prompt))
+		}
+	}
+	return permit(user), nil
+}
+
+// isAuthorizedKey reports whether key is one of the user's authorized keys.
+func isAuthorizedKey(user *storage.SshUser, key ssh.PublicKey) bool {
+	marshaled := key.Marshal()
+	for _, line := range user.AuthorizedKeys {
+		authorized, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err == nil && bytes.Equal(authorized.Marshal(), marshaled) {
+			return true
+		}
+	}
+	return false
+}
+
+func authFailed(c ssh.ConnMetadata, method string, err error) error {
+	log.Printf("SSH %s authentication failed for %s from %s: %v", method, c.User(), c.RemoteAddr(), err)
+	return err
+}
+
+// session identifies who runs the commands of a connection.
+type session struct {
+	username string
+	project  string              // the user's project, empty to use the config's
+	profile  *storage.SshProfile // the device CLI to emulate, nil for a plain shell
+	closed   <-chan struct{}     // closed once the client closes the channel or disconnects
+}
+
+// hostname is the emulated device's hostname, empty without a profile.
+func (sess session) hostname() string {
+	if sess.profile == nil {
+		return ""
+	}
+	return sess.profile.Hostname
+}
+
+// initialMode is the mode a shell starts in and exec commands run in.
+func (sess session) initialMode() string {
+	if sess.profile == nil {
+		return ""
+	}
+	return sess.profile.InitialMode
+}
+
+// loadProfile finds the device profile for a user, if any.
+func (s *SSHServer) loadProfile(username string) *storage.SshProfile {
+	user, err := s.db.GetSshUser(username)
+	if err == nil {
+		var profile *storage.SshProfile
+		if profile, err = s.db.GetSshProfileForUser(user); err == nil {
+			return profile
+		}
+	}
+	log.Printf("Failed to load SSH profile for %s: %v", username, err)
+	return nil
 }
 
 // Start listens for and handles incoming SSH connections.
@@ -73,7 +243,11 @@ func (s *SSHServer) handleConnection(nConn net.Conn) {
 		return
 	}
 	defer conn.Close()
-	log.Printf("New SSH connection from %s (%s)", conn.RemoteAddr(), conn.ClientVersion())
+	log.Printf("New SSH connection from %s as %s (%s)", conn.RemoteAddr(), conn.User(), conn.ClientVersion())
+	sess := session{username: conn.User(), profile: s.loadProfile(conn.User())}
+	if conn.Permissions != nil {
+		sess.project = conn.Permissions.Extensions[projectExtension]
+	}
 
 	go ssh.DiscardRequests(reqs)
 
@@ -88,14 +262,28 @@ func (s *SSHServer) handleConnection(nConn net.Conn) {
 			continue
 		}
 
+		// The request stream ends when the channel or the whole connection goes away,
+		// which is how commands held for an operator notice the client has left.
+		closed := make(chan struct{})
+		sess := sess
+		sess.closed = closed
 		go func(in <-chan *ssh.Request) {
+			defer close(closed)
 			for req := range in {
 				switch req.Type {
 				case "shell", "pty-req":
 					req.Reply(true, nil)
 					if req.Type == "shell" {
-						go s.handleShell(channel)
+						go s.handleShell(channel, sess)
 					}
+				case "exec":
+					var payload struct{ Command string }
+					if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+						req.Reply(false, nil)
+						continue
+					}
+					req.Reply(true, nil)
+					go s.handleExec(channel, sess, payload.Command)
 				default:
 					req.Reply(false, nil)
 				}
@@ -105,34 +293,88 @@ func (s *SSHServer) handleConnection(nConn net.Conn) {
 }
 
 // handleShell manages an interactive shell session for an SSH connection.
-func (s *SSHServer) handleShell(channel ssh.Channel) {
+func (s *SSHServer) handleShell(channel ssh.Channel, sess session) {
 	defer channel.Close()
 	term := &mockTerminal{
-		sshChannel:  channel,
-		bus:         s.bus,
-		db:          s.db,
-		pendingReqs: make(map[string]chan string),
+		sshChannel: channel,
+		server:     s,
+		session:    sess,
+		mode:       sess.initialMode(),
 	}
 	term.Run()
+	sendExitStatus(channel, 0)
+}
+
+// handleExec runs a single non-interactive command, as sent by `ssh host cmd`
+// and by automation libraries, then reports its exit status and closes the channel.
+// With a device profile the command runs in the profile's initial mode.
+func (s *SSHServer) handleExec(channel ssh.Channel, sess session, command string) {
+	defer channel.Close()
+	command = storage.NormalizeSshCommand(command)
+	if sess.profile != nil {
+		if tr := sess.profile.Transition(sess.initialMode(), command); tr != nil {
+			s.recordTransition(sess, sess.initialMode(), command, tr)
+			channel.Write([]byte(withNewline(tr.Output, "\n")))
+			sendExitStatus(channel, 0)
+			return
+		}
+	}
+	output := s.runCommand(sess, sess.initialMode(), command)
+	channel.Write([]byte(withNewline(output.Body, "\n")))
+	channel.Stderr().Write([]byte(withNewline(output.Stderr, "\n")))
+	sendExitStatus(channel, uint32(output.ExitCode))
+}
+
+// terminalText converts line endings to the CRLF a terminal without a pty line discipline needs.
+func terminalText(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+}
+
+// withNewline terminates non-empty output with newline unless it already ends a line.
+func withNewline(output, newline string) string {
+	if output == "" || strings.HasSuffix(output, "\n") {
+		return output
+	}
+	return output + newline
+}
+
+// sendExitStatus tells the client how the command or shell ended. Clients such as
+// Go's session.Run report an error if the channel closes without it.
+func sendExitStatus(channel ssh.Channel, status uint32) {
+	payload := ssh.Marshal(struct{ Status uint32 }{status})
+	if _, err := channel.SendRequest("exit-status", false, payload); err != nil && err != io.EOF {
+		log.Printf("Failed to send SSH exit status: %v", err)
+	}
 }
 
 type mockTerminal struct {
-	sshChannel    ssh.Channel
-	bus           *bus.JsonEventBus
-	db            *storage.DB
-	pendingReqs   map[string]chan string
-	pendingReqsMu sync.Mutex
+	sshChannel ssh.Channel
+	server     *SSHServer
+	session    session
+	mode       string // current device CLI mode, empty without a profile
+}
+
+// prompt returns the prompt of the current mode.
+func (t *mockTerminal) prompt() string {
+	if t.session.profile == nil {
+		return "> "
+	}
+	return t.session.profile.Prompt(t.mode)
 }
 
 // Run starts the interactive terminal session, reading commands char by char.
 func (t *mockTerminal) Run() {
-	t.sshChannel.Write([]byte("Welcome to the ZYUC Mock SSH server!\r\n"))
+	if profile := t.session.profile; profile != nil {
+		t.sshChannel.Write([]byte(withNewline(terminalText(profile.Banner), "\r\n")))
+	} else {
+		t.sshChannel.Write([]byte("Welcome to the ZYUC Mock SSH server!\r\n"))
+	}
 
 	var line []byte
 	buffer := make([]byte, 1)
 
 	for {
-		t.sshChannel.Write([]byte("> "))
+		t.sshChannel.Write([]byte(t.prompt()))
 		line = nil // Reset line buffer for new command
 
 		for {
@@ -152,10 +394,16 @@ func (t *mockTerminal) Run() {
 			// Handle Enter key (CR or LF)
 			if char == '\r' || char == '\n' {
 				t.sshChannel.Write([]byte("\r\n")) // Echo newline
-				command := strings.TrimSpace(string(line))
+				command := storage.NormalizeSshCommand(string(line))
 
+				// Mode transitions come first so that "exit" can leave a sub-mode
+				if command != "" && t.changeMode(command) {
+					break
+				}
 				if command == "exit" {
-					t.sshChannel.Write([]byte("Goodbye!\r\n"))
+					if t.session.profile == nil {
+						t.sshChannel.Write([]byte("Goodbye!\r\n"))
+					}
 					return // End session
 				}
 
@@ -183,55 +431,155 @@ func (t *mockTerminal) Run() {
 	}
 }
 
-// handleCommand processes a single command received from the terminal.
-func (t *mockTerminal) handleCommand(command string) {
-	reqID := uuid.New().String()
-	sshConfig, _ := t.db.GetSshConfigForCommand(command)
+// changeMode applies the profile's transition for command in the current mode,
+// if there is one, and reports whether it did.
+func (t *mockTerminal) changeMode(command string) bool {
+	if t.session.profile == nil {
+		return false
+	}
+	tr := t.session.profile.Transition(t.mode, command)
+	if tr == nil {
+		return false
+	}
+	t.server.recordTransition(t.session, t.mode, command, tr)
+	t.sshChannel.Write([]byte(withNewline(terminalText(tr.Output), "\r\n")))
+	t.mode = tr.To
+	return true
+}
 
-	var responseToSend string
-	var project string
-	if sshConfig != nil {
-		responseToSend = sshConfig.Response
-		project = sshConfig.Project
-	} else {
-		responseToSend = fmt.Sprintf("Command '%s' not found.", command)
+// recordTransition adds a mode change to the history. It is answered by the
+// profile, so it is never held for an operator and always exits 0.
+func (s *SSHServer) recordTransition(sess session, mode, command string, tr *storage.SshTransition) {
+	event := &storage.SshEvent{
+		RequestID: uuid.New().String(),
+		Command:   command,
+		Project:   sess.project,
+		Username:  sess.username,
+		Mode:      mode,
 	}
+	recordSshOutput(event, pending.Response{Body: tr.Output}, "Mode Changed ("+tr.To+")")
+	if err := s.db.CreateSshEvent(event); err != nil {
+		log.Printf("Failed to save SSH event: %v", err)
+	}
+}
 
-	if err := t.db.CreateSshEvent(reqID, command, project, "", "Pending"); err != nil {
-		log.Printf("Failed to save pending SSH event: %v", err)
+// handleCommand processes a single command received from the terminal. Both
+// streams go to the terminal; the exit code only matters to exec sessions.
+func (t *mockTerminal) handleCommand(command string) {
+	output := t.server.runCommand(t.session, t.mode, command)
+	if t.session.profile != nil {
+		// Devices print nothing for commands without output, such as most config commands
+		t.sshChannel.Write([]byte(withNewline(output.Body, "\r\n")))
+	} else if output.Body != "" || output.Stderr == "" {
+		t.sshChannel.Write([]byte(output.Body + "\r\n"))
 	}
+	t.sshChannel.Stderr().Write([]byte(withNewline(output.Stderr, "\r\n")))
+}
 
-	responseChan := make(chan string, 1)
+// notFoundExitCode is what shells return for an unknown command.
+const notFoundExitCode = 127
+
+// runCommand looks up the configured output for a command, records it in the
+// history and, while a UI is connected, holds it for an operator reply. Unknown
+// commands print an error to stderr and exit with notFoundExitCode. The event
+// belongs to the user's project when it has one, otherwise to the config's.
+// Configs scoped to mode take precedence over those for any mode. The command
+// is expected to be normalized with storage.NormalizeSshCommand.
+func (s *SSHServer) runCommand(sess session, mode, command string) pending.Response {
+	event := &storage.SshEvent{RequestID: uuid.New().String(), Command: command, Username: sess.username, Mode: mode}
+	sshConfig, args, err := s.db.GetSshConfigForCommand(command, mode)
+	if err != nil {
+		log.Printf("Failed to look up SSH config for %q: %v", command, err)
+	}
 
-	t.pendingReqsMu.Lock()
-	t.pendingReqs[reqID] = responseChan
-	t.pendingReqsMu.Unlock()
+	var output pending.Response
+	var holdOverride *int
+	if sshConfig != nil {
+		output = pending.Response{Body: sshConfig.Response, Stderr: sshConfig.Stderr, ExitCode: sshConfig.ExitCode}
+		event.Project = sshConfig.Project
+		holdOverride = sshConfig.HoldTimeout
+	} else {
+		output = pending.Response{Stderr: fmt.Sprintf("Command '%s' not found.", command), ExitCode: notFoundExitCode}
+	}
+	if sess.project != "" {
+		event.Project = sess.project
+	}
+	autoStatus := "Auto-Responded"
+	if sshConfig != nil {
+		autoStatus += renderConfiguredOutput(&output, templateData{
+			RequestID: event.RequestID,
+			Command:   command,
+			Fields:    strings.Fields(command),
+			Args:      args,
+			Username:  sess.username,
+			Project:   event.Project,
+			Mode:      mode,
+			Hostname:  sess.hostname(),
+		})
+	}
 
-	defer func() {
-		t.pendingReqsMu.Lock()
-		delete(t.pendingReqs, reqID)
-		t.pendingReqsMu.Unlock()
-	}()
+	// Without a live UI nobody can answer, so reply straight away like the HTTP broker does.
+	if s.bus.InteractiveSubscriberCount() == 0 {
+		recordSshOutput(event, output, autoStatus)
+		if err := s.db.CreateSshEvent(event); err != nil {
+			log.Printf("Failed to save SSH event: %v", err)
+		}
+		return output
+	}
 
-	ssePayload := map[string]string{
-		"requestId":       reqID,
-		"command":         command,
-		"project":         project,
-		"defaultResponse": responseToSend,
-		"type":            "ssh",
+	event.Status = "Pending"
+	if err := s.db.CreateSshEvent(event); err != nil {
+		log.Printf("Failed to save pending SSH event: %v", err)
 	}
 
-	ssePayloadJSON, _ := json.Marshal(ssePayload)
-	t.bus.Publish(string(ssePayloadJSON))
+	// The registry announces the command to the UI and delivers the default
+	// response once the hold expires; a negative hold waits for the operator.
+	pr := &pending.Request{
+		ID:              event.RequestID,
+		Type:            pending.TypeSSH,
+		Endpoint:        command,
+		Project:         event.Project,
+		DefaultResponse: output,
+		TimeoutStatus:   autoStatus,
+	}
+	s.pending.Add(pr, s.db.ResolveHoldTimeout(holdOverride, event.Project, s.holdTimeout))
+	defer s.pending.Remove(event.RequestID)
 
+	var reply pending.Reply
 	select {
-	case responseBody := <-responseChan:
-		log.Printf("Responding to SSH command %s with user response.", reqID)
-		t.db.UpdateSshEventResponse(reqID, responseBody, "Responded (Custom)")
-		t.sshChannel.Write([]byte(responseBody + "\r\n"))
-	case <-time.After(0 * time.Second):
-		log.Printf("SSH command %s timed out after 0 seconds.", reqID)
-		t.db.UpdateSshEventResponse(reqID, responseToSend, "Auto-Responded")
-		t.sshChannel.Write([]byte(responseToSend + "\r\n"))
+	case reply = <-pr.Replies():
+		log.Printf("Responding to SSH command %s: %s.", event.RequestID, reply.Status)
+		recordSshOutput(event, reply.Response, reply.Status)
+	case <-sess.closed:
+		log.Printf("SSH client for command %s disconnected.", event.RequestID)
+		event.Status = "Cancelled"
+	}
+	if err := s.db.UpdateSshEventResponse(event); err != nil {
+		log.Printf("Failed to update SSH event %s: %v", event.RequestID, err)
 	}
+	return reply.Response
+}
+
+// renderConfiguredOutput renders the configured stdout and stderr templates in place.
+// Like the HTTP broker it keeps the raw text when rendering fails and returns a
+// suffix noting the error for the event status.
+func renderConfiguredOutput(output *pending.Response, data templateData) string {
+	body, err := renderOutput(output.Body, data)
+	if err == nil {
+		var stderr string
+		if stderr, err = renderOutput(output.Stderr, data); err == nil {
+			output.Body, output.Stderr = body, stderr
+			return ""
+		}
+	}
+	log.Printf("Failed to render SSH output for %s: %v", data.RequestID, err)
+	return " (Template Error: " + err.Error() + ")"
+}
+
+func recordSshOutput(event *storage.SshEvent, output pending.Response, status string) {
+	exitCode := output.ExitCode
+	event.ResponseBody = output.Body
+	event.Stderr = output.Stderr
+	event.ExitCode = &exitCode
+	event.Status = status
 }
@@ -0,0 +1,59 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// templateData is what an SSH output template can refer to, e.g. {{.Args.host}}
+// for `ping {host}` or {{index .Fields 1}} for the second word of the command.
+type templateData struct {
+	RequestID string
+	Command   string            // the normalized command
+	Fields    []string          // the words of the command
+	Args      map[string]string // arguments captured by the matching pattern
+	Username  string
+	Project   string
+	Mode      string // the device CLI mode the command ran in
+	Hostname  string // the device profile's hostname
+}
+
+var templateFuncs = template.FuncMap{
+	"now": func(layout ...string) string {
+		if len(layout) > 0 {
+			return time.Now().Format(layout[0])
+		}
+		return time.Now().Format(time.RFC3339)
+	},
+	"timestamp": func() int64 { return time.Now().Unix() },
+	"uuid":      func() string { return uuid.New().String() },
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"default": func(def string, value interface{}) string {
+		if s := fmt.Sprint(value); value != nil && s != "" {
+			return s
+		}
+		return def
+	},
+}
+
+// renderOutput renders configured output as a template. Output without {{ is
+// returned as is so that plain text is never parsed.
+func renderOutput(output string, data templateData) (string, error) {
+	if !strings.Contains(output, "{{") {
+		return output, nil
+	}
+	tmpl, err := template.New("output").Funcs(templateFuncs).Option("missingkey=zero").Parse(output)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 接口路径的三种形式：
+//   - 精确路径：/orders/list
+//   - 模板路径：/orders/{id}、/files/*、/static/**，{name} 与 * 匹配单个路径段，** 只能出现在末尾并匹配剩余的任意层级
+//   - 正则路径：以 ~ 开头，如 ~^/orders/(?P<id>\d+)$，具名分组作为路径变量
+const (
+	endpointExact = iota
+	endpointTemplate
+	endpointRegex
+)
+
+// 模板路径段的具体程度，数值越大越具体
+const (
+	segmentRest = iota
+	segmentWildcard
+	segmentParam
+	segmentLiteral
+)
+
+var templateParamRe = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// EndpointPattern 是编译后的接口路径。
+type EndpointPattern struct {
+	Raw      string
+	kind     int
+	re       *regexp.Regexp
+	segments []int
+}
+
+// IsEndpointPattern 判断接口路径是否为模板或正则形式。
+func IsEndpointPattern(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "~") || strings.ContainsAny(endpoint, "{*")
+}
+
+// ParseEndpointPattern 编译接口路径，非法的模板或正则返回错误。
+func ParseEndpointPattern(endpoint string) (*EndpointPattern, error) {
+	if strings.HasPrefix(endpoint, "~") {
+		re, err := regexp.Compile(endpoint[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint regex: %v", err)
+		}
+		return &EndpointPattern{Raw: endpoint, kind: endpointRegex, re: re}, nil
+	}
+	if !strings.HasPrefix(endpoint, "/") {
+		return nil, fmt.Errorf("endpoint must start with '/' or '~'")
+	}
+	if !IsEndpointPattern(endpoint) {
+		return &EndpointPattern{Raw: endpoint, kind: endpointExact}, nil
+	}
+
+	parts := strings.Split(endpoint[1:], "/")
+	var expr strings.Builder
+	expr.WriteString("^")
+	segments := make([]int, 0, len(parts))
+	seen := make(map[string]bool)
+	for i, part := range parts {
+		switch {
+		case part == "**":
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("'**' is only allowed as the last segment")
+			}
+			expr.WriteString("(?:/.*)?")
+			segments = append(segments, segmentRest)
+			continue
+		case part == "*":
+			expr.WriteString("/[^/]+")
+			segments = append(segments, segmentWildcard)
+			continue
+		case strings.Contains(part, "*"):
+			return nil, fmt.Errorf("'*' must occupy a whole segment in %q", part)
+		}
+
+		expr.WriteString("/")
+		matches := templateParamRe.FindAllStringSubmatchIndex(part, -1)
+		last := 0
+		for _, m := range matches {
+			name := part[m[2]:m[3]]
+			if seen[name] {
+				return nil, fmt.Errorf("duplicate path variable %q", name)
+			}
+			seen[name] = true
+			if err := writeLiteral(&expr, part[last:m[0]]); err != nil {
+				return nil, err
+			}
+			expr.WriteString("(?P<" + name + ">[^/]+)")
+			last = m[1]
+		}
+		if err := writeLiteral(&expr, part[last:]); err != nil {
+			return nil, err
+		}
+		if len(matches) > 0 {
+			segments = append(segments, segmentParam)
+		} else {
+			segments = append(segments, segmentLiteral)
+		}
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint template: %v", err)
+	}
+	return &EndpointPattern{Raw: endpoint, kind: endpointTemplate, re: re, segments: segments}, nil
+}
+
+func writeLiteral(expr *strings.Builder, literal string) error {
+	if strings.ContainsAny(literal, "{}") {
+		return fmt.Errorf("invalid path variable near %q", literal)
+	}
+	expr.WriteString(regexp.QuoteMeta(literal))
+	return nil
+}
+
+// Match 判断请求路径是否匹配，并返回捕获的路径变量。
+func (p *EndpointPattern) Match(path string) (map[string]string, bool) {
+	if p.kind == endpointExact {
+		return nil, p.Raw == path
+	}
+	m := p.re.FindStringSubmatch(path)
+	if m == nil {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, name := range p.re.SubexpNames() {
+		if name != "" && i < len(m) {
+			params[name] = m[i]
+		}
+	}
+	return params, true
+}
+
+// moreSpecific 判断 p 是否比 q 更具体：精确 > 模板 > 正则；模板之间逐段比较，段更具体者优先，全部相同时段数多者优先；正则之间表达式长者优先。
+func (p *EndpointPattern) moreSpecific(q *EndpointPattern) (better bool, decided bool) {
+	if p.kind != q.kind {
+		return p.kind < q.kind, true
+	}
+	switch p.kind {
+	case endpointTemplate:
+		for i := 0; i < len(p.segments) && i < len(q.segments); i++ {
+			if p.segments[i] != q.segments[i] {
+				return p.segments[i] > q.segments[i], true
+			}
+		}
+		if len(p.segments) != len(q.segments) {
+			return len(p.segments) > len(q.segments), true
+		}
+	case endpointRegex:
+		if len(p.Raw) != len(q.Raw) {
+			return len(p.Raw) > len(q.Raw), true
+		}
+	}
+	return false, false
+}
+
+type configCandidate struct {
+	config  Config
+	pattern *EndpointPattern
+	params  map[string]string
+}
+
+// sortCandidates 按“路径具体程度 > 指定设备 > 指定方法 > 创建顺序”排序。
+func sortCandidates(candidates []configCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if better, decided := a.pattern.moreSpecific(b.pattern); decided {
+			return better
+		}
+		if (a.config.Source == "") != (b.config.Source == "") {
+			return a.config.Source != ""
+		}
+		if (a.config.Method == "") != (b.config.Method == "") {
+			return a.config.Method != ""
+		}
+		return a.config.ID < b.config.ID
+	})
+}
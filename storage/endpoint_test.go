@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestEndpointPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+		params  map[string]string
+	}{
+		{"/orders/list", "/orders/list", true, nil},
+		{"/orders/list", "/orders/list/", false, nil},
+		{"/orders/{id}", "/orders/42", true, map[string]string{"id": "42"}},
+		{"/orders/{id}", "/orders/42/items", false, nil},
+		{"/orders/{id}", "/orders/", false, nil},
+		{"/orders/{id}/items/{item}", "/orders/42/items/7", true, map[string]string{"id": "42", "item": "7"}},
+		{"/files/v{version}.json", "/files/v2.json", true, map[string]string{"version": "2"}},
+		{"/files/v{version}.json", "/files/v2xjson", false, nil},
+		{"/files/*", "/files/a.txt", true, nil},
+		{"/files/*", "/files/a/b.txt", false, nil},
+		{"/static/**", "/static", true, nil},
+		{"/static/**", "/static/css/site.css", true, nil},
+		{"/static/**", "/statics", false, nil},
+		{`~^/orders/(?P<id>\d+)$`, "/orders/42", true, map[string]string{"id": "42"}},
+		{`~^/orders/(?P<id>\d+)$`, "/orders/abc", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			p, err := ParseEndpointPattern(tt.pattern)
+			if err != nil {
+				t.Fatalf("ParseEndpointPattern: %v", err)
+			}
+			params, ok := p.Match(tt.path)
+			if ok != tt.want {
+				t.Fatalf("Match = %v, want %v", ok, tt.want)
+			}
+			if ok && tt.params != nil && !reflect.DeepEqual(params, tt.params) {
+				t.Fatalf("params = %v, want %v", params, tt.params)
+			}
+		})
+	}
+}
+
+func TestParseEndpointPatternErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"orders/{id}",
+		"/static/**/css",
+		"/files/a*",
+		"/orders/{id}/{id}",
+		"/orders/{id",
+		"/orders/{1d}",
+		"~^/orders/(",
+	} {
+		if _, err := ParseEndpointPattern(pattern); err == nil {
+			t.Errorf("ParseEndpointPattern(%q) succeeded, want error", pattern)
+		}
+	}
+}
+
+func TestSortCandidates(t *testing.T) {
+	configs := []Config{
+		{Model: gorm.Model{ID: 1}, Endpoint: `~^/orders/.*$`},
+		{Model: gorm.Model{ID: 2}, Endpoint: "/orders/**"},
+		{Model: gorm.Model{ID: 3}, Endpoint: "/orders/*"},
+		{Model: gorm.Model{ID: 4}, Endpoint: "/orders/{id}"},
+		{Model: gorm.Model{ID: 5}, Endpoint: "/orders/{id}", Method: "GET"},
+		{Model: gorm.Model{ID: 6}, Endpoint: "/orders/{id}", Source: "device-a"},
+		{Model: gorm.Model{ID: 7}, Endpoint: "/orders/42"},
+		{Model: gorm.Model{ID: 8}, Endpoint: `~^/orders/(?P<id>\d+)$`},
+	}
+	candidates := make([]configCandidate, 0, len(configs))
+	for _, cfg := range configs {
+		p, err := ParseEndpointPattern(cfg.Endpoint)
+		if err != nil {
+			t.Fatalf("ParseEndpointPattern(%q): %v", cfg.Endpoint, err)
+		}
+		candidates = append(candidates, configCandidate{config: cfg, pattern: p})
+	}
+	sortCandidates(candidates)
+	var got []uint
+	for _, c := range candidates {
+		got = append(got, c.config.ID)
+	}
+	want := []uint{7, 6, 5, 4, 3, 2, 8, 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
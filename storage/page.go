@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultSort 是历史记录的默认排序：按时间倒序。
+const DefaultSort = "-timestamp"
+
+// ErrInvalidPage 表示排序字段或分页游标不合法。
+var ErrInvalidPage = errors.New("invalid sort or cursor")
+
+// PageRequest 描述历史记录的排序与分页方式。
+type PageRequest struct {
+	Sort      string // 排序字段，前缀 - 表示倒序，为空时使用 DefaultSort
+	Page      int    // 从 1 开始的页码，Cursor 不为空时忽略
+	PageSize  int
+	Cursor    string // 上一页返回的 NextCursor，按游标继续读取
+	WithTotal bool   // 是否统计符合条件的总数
+}
+
+// PageResult 是分页查询的附加信息。
+type PageResult struct {
+	Total      *int64 // 未统计时为 nil
+	NextCursor string // 没有更多记录时为空
+}
+
+// sortColumn 是可排序字段对应的 SQL 表达式，param 为游标值与之比较时参数的写法。
+type sortColumn struct {
+	expr  string
+	param string
+}
+
+// timestampColumn 按儒略日比较时间列，见 timeCondition。
+var timestampColumn = sortColumn{expr: "julianday(timestamp)", param: "julianday(?)"}
+
+// 可排序的字段及对应的列，旧记录中缺失的值按零值排序
+var (
+	eventSortColumns = map[string]sortColumn{
+		"timestamp":  timestampColumn,
+		"endpoint":   {expr: "COALESCE(endpoint, '')", param: "?"},
+		"statusCode": {expr: "COALESCE(status_code, 0)", param: "?"},
+		"latencyMs":  {expr: "COALESCE(latency_ms, 0)", param: "?"},
+	}
+	sshEventSortColumns = map[string]sortColumn{
+		"timestamp": timestampColumn,
+		"command":   {expr: "COALESCE(command, '')", param: "?"},
+	}
+)
+
+// pageCursor 是游标的内容：排序方式、上一页最后一条记录的排序值与 ID。
+type pageCursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+// findPage 按 req 排序并读取一页记录。游标分页以（排序值, ID）为键继续读取，
+// 不受翻页期间新写入记录的影响；key 返回记录的排序值与 ID。
+func findPage[T any](query *gorm.DB, columns map[string]sortColumn, req PageRequest, key func(*T, string) (interface{}, uint)) ([]T, PageResult, error) {
+	var result PageResult
+	sort := req.Sort
+	if sort == "" {
+		sort = DefaultSort
+	}
+	desc := strings.HasPrefix(sort, "-")
+	field := strings.TrimPrefix(sort, "-")
+	column, ok := columns[field]
+	if !ok {
+		return nil, result, fmt.Errorf("%w: unknown sort field %q", ErrInvalidPage, field)
+	}
+
+	if req.WithTotal {
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return nil, result, err
+		}
+		result.Total = &total
+	}
+
+	cmp, dir := ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+	query = query.Order(fmt.Sprintf("%s %s, id %s", column.expr, dir, dir)).Limit(req.PageSize + 1)
+	if req.Cursor != "" {
+		cursor, value, err := decodeCursor(req.Cursor, sort, field)
+		if err != nil {
+			return nil, result, err
+		}
+		query = query.Where(fmt.Sprintf("(%[1]s %[2]s %[3]s) OR (%[1]s = %[3]s AND id %[2]s ?)", column.expr, cmp, column.param), value, value, cursor.ID)
+	} else if req.Page > 1 {
+		query = query.Offset((req.Page - 1) * req.PageSize)
+	}
+
+	var rows []T
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, result, err
+	}
+	if len(rows) > req.PageSize {
+		rows = rows[:req.PageSize]
+		value, id := key(&rows[len(rows)-1], field)
+		next, err := encodeCursor(sort, value, id)
+		if err != nil {
+			return nil, result, err
+		}
+		result.NextCursor = next
+	}
+	return rows, result, nil
+}
+
+func encodeCursor(sort string, value interface{}, id uint) (string, error) {
+	if t, ok := value.(time.Time); ok {
+		value = t.Format(time.RFC3339Nano)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(pageCursor{Sort: sort, Value: raw, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor 解析游标，检查它是否按同一方式排序，并按排序字段把排序值还原为可与列比较的类型。
+func decodeCursor(s, sort, field string) (pageCursor, interface{}, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &cursor) != nil {
+		return cursor, nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+	}
+	if cursor.Sort != sort {
+		return cursor, nil, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidPage, cursor.Sort)
+	}
+	var value interface{}
+	switch field {
+	case "timestamp":
+		var v string
+		if err := json.Unmarshal(cursor.Value, &v); err != nil {
+			return cursor, nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return cursor, nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+		}
+		value = t
+	case "statusCode", "latencyMs":
+		var v int64
+		if err := json.Unmarshal(cursor.Value, &v); err != nil {
+			return cursor, nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+		}
+		value = v
+	default:
+		var v string
+		if err := json.Unmarshal(cursor.Value, &v); err != nil {
+			return cursor, nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+		}
+		value = v
+	}
+	return cursor, value, nil
+}
+
+func eventSortKey(e *Event, field string) (interface{}, uint) {
+	switch field {
+	case "endpoint":
+		return e.Endpoint, e.ID
+	case "statusCode":
+		return e.StatusCode, e.ID
+	case "latencyMs":
+		return e.LatencyMs, e.ID
+	default:
+		return e.Timestamp, e.ID
+	}
+}
+
+func sshEventSortKey(e *SshEvent, field string) (interface{}, uint) {
+	if field == "command" {
+		return e.Command, e.ID
+	}
+	return e.Timestamp, e.ID
+}
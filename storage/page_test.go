@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	return db
+}
+
+// mixedOffsetEvents 写入 n 条间隔 1 秒的记录，时间依次以 +08:00、UTC 与 -05:00 保存，
+// 按文本排序与按时间排序的结果不同。
+func mixedOffsetEvents(t *testing.T, db *DB, n int) time.Time {
+	t.Helper()
+	zones := []*time.Location{
+		time.FixedZone("CST", 8*3600),
+		time.UTC,
+		time.FixedZone("EST", -5*3600),
+	}
+	base := time.Date(2025, 7, 30, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		event := &Event{
+			RequestID: fmt.Sprintf("req-%03d", i),
+			Endpoint:  fmt.Sprintf("/orders/%d", i),
+			Timestamp: base.Add(time.Duration(i) * time.Second).In(zones[i%len(zones)]),
+		}
+		if err := db.CreateEvent(event); err != nil {
+			t.Fatalf("CreateEvent: %v", err)
+		}
+	}
+	return base
+}
+
+func TestGetEventsCursorWalksEveryRowOnce(t *testing.T) {
+	db := newTestDB(t)
+	const total = 130
+	mixedOffsetEvents(t, db, total)
+
+	for _, sort := range []string{"-timestamp", "timestamp", "endpoint", "-endpoint"} {
+		t.Run(sort, func(t *testing.T) {
+			seen := make(map[string]bool)
+			var last time.Time
+			page := PageRequest{Sort: sort, PageSize: 7}
+			for pages := 0; ; pages++ {
+				if pages > total {
+					t.Fatalf("cursor walk did not terminate")
+				}
+				events, result, err := db.GetEvents(EventFilter{}, page)
+				if err != nil {
+					t.Fatalf("GetEvents: %v", err)
+				}
+				for _, e := range events {
+					if seen[e.RequestID] {
+						t.Fatalf("%s returned twice", e.RequestID)
+					}
+					seen[e.RequestID] = true
+					if sort == "-timestamp" && !last.IsZero() && e.Timestamp.After(last) {
+						t.Fatalf("%s at %v is after the previous row at %v", e.RequestID, e.Timestamp, last)
+					}
+					if sort == "timestamp" && e.Timestamp.Before(last) {
+						t.Fatalf("%s at %v is before the previous row at %v", e.RequestID, e.Timestamp, last)
+					}
+					last = e.Timestamp
+				}
+				if result.NextCursor == "" {
+					break
+				}
+				page.Cursor = result.NextCursor
+			}
+			if len(seen) != total {
+				t.Fatalf("visited %d rows, want %d", len(seen), total)
+			}
+		})
+	}
+}
+
+func TestEventFilterTimeRangeAcrossOffsets(t *testing.T) {
+	db := newTestDB(t)
+	base := mixedOffsetEvents(t, db, 30)
+
+	tests := []struct {
+		name         string
+		since, until time.Time
+		want         int
+	}{
+		{"since", base.Add(10 * time.Second), time.Time{}, 20},
+		{"until", time.Time{}, base.Add(10 * time.Second), 10},
+		{"range in another zone", base.Add(5 * time.Second).In(time.FixedZone("", 3*3600)), base.Add(15 * time.Second), 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, result, err := db.GetEvents(EventFilter{Since: tt.since, Until: tt.until}, PageRequest{PageSize: 100, WithTotal: true})
+			if err != nil {
+				t.Fatalf("GetEvents: %v", err)
+			}
+			if *result.Total != int64(tt.want) {
+				t.Fatalf("got %d events, want %d", *result.Total, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejectsOtherSort(t *testing.T) {
+	cursor, err := encodeCursor("-timestamp", time.Now(), 1)
+	if err != nil {
+		t.Fatalf("encodeCursor: %v", err)
+	}
+	if _, _, err := decodeCursor(cursor, "timestamp", "timestamp"); err == nil {
+		t.Fatalf("cursor issued for -timestamp accepted for timestamp")
+	}
+	if _, _, err := decodeCursor("not a cursor", "-timestamp", "timestamp"); err == nil {
+		t.Fatalf("malformed cursor accepted")
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HoldIndefinitely 作为 HoldTimeout 的取值时表示一直挂起，直到操作员响应或调用方断开。
+const HoldIndefinitely = -1
+
+// ProjectSetting 保存按工程生效的设置，字段为 nil 时沿用全局设置。
+type ProjectSetting struct {
+	Project        string `gorm:"primaryKey"`
+	HoldTimeout    *int   // 秒，HoldIndefinitely 表示一直等待
+	HistoryMaxAge  *int   // 历史记录保留的秒数，0 表示不按时长清理
+	HistoryMaxRows *int   // 该工程每张历史表最多保留的记录数，0 表示不限制
+	UpdatedAt      time.Time
+}
+
+// GetProjectSetting 返回工程设置，未设置过时返回 nil。
+func (db *DB) GetProjectSetting(project string) (*ProjectSetting, error) {
+	var setting ProjectSetting
+	err := db.Where("project = ?", project).First(&setting).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &setting, nil
+}
+
+// GetAllProjectSettings 返回所有工程设置。
+func (db *DB) GetAllProjectSettings() ([]ProjectSetting, error) {
+	var settings []ProjectSetting
+	err := db.Order("project").Find(&settings).Error
+	return settings, err
+}
+
+// SetProjectSetting 创建或整体替换工程设置。
+func (db *DB) SetProjectSetting(setting *ProjectSetting) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "project"}},
+		DoUpdates: clause.AssignmentColumns([]string{"hold_timeout", "history_max_age", "history_max_rows", "updated_at"}),
+	}).Create(setting).Error
+}
+
+// ResolveHoldTimeout 依次按配置、工程、全局的顺序确定挂起时长，返回负值表示一直等待。
+// 不属于任何工程的请求直接沿用全局设置。
+func (db *DB) ResolveHoldTimeout(override *int, project string, global time.Duration) time.Duration {
+	seconds := override
+	if seconds == nil && project != "" {
+		if setting, err := db.GetProjectSetting(project); err == nil && setting != nil {
+			seconds = setting.HoldTimeout
+		}
+	}
+	if seconds == nil {
+		return global
+	}
+	if *seconds < 0 {
+		return -1
+	}
+	return time.Duration(*seconds) * time.Second
+}
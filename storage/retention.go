@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetentionPolicy 是历史记录的全局保留策略，字段为 0 表示不限制。
+type RetentionPolicy struct {
+	MaxAge  time.Duration // 删除早于该时长的记录
+	MaxRows int           // 每张历史表最多保留的记录数，超出时删除最早的记录
+}
+
+// PruneResult 是一次清理从各历史表中删除的记录数。
+type PruneResult struct {
+	Events    int64 `json:"events"`
+	SshEvents int64 `json:"sshEvents"`
+}
+
+// PruneHistory 按工程与全局的保留策略物理删除 Event 与 SshEvent，并清除此前被软删除的记录。
+// 工程设置了保留时长时该工程的记录只按工程设置清理；工程与全局的条数上限分别按工程和整张表生效。
+func (db *DB) PruneHistory(global RetentionPolicy) (PruneResult, error) {
+	var result PruneResult
+	settings, err := db.GetAllProjectSettings()
+	if err != nil {
+		return result, err
+	}
+	if result.Events, err = db.pruneTable(&Event{}, global, settings); err != nil {
+		return result, err
+	}
+	result.SshEvents, err = db.pruneTable(&SshEvent{}, global, settings)
+	return result, err
+}
+
+func (db *DB) pruneTable(model interface{}, global RetentionPolicy, settings []ProjectSetting) (int64, error) {
+	var deleted int64
+	remove := func(query *gorm.DB) error {
+		res := query.Delete(model)
+		deleted += res.RowsAffected
+		return res.Error
+	}
+
+	if err := remove(db.Unscoped().Where("deleted_at IS NOT NULL")); err != nil {
+		return deleted, err
+	}
+
+	now := time.Now()
+	var ageOverridden []string
+	for _, setting := range settings {
+		if setting.HistoryMaxAge != nil {
+			ageOverridden = append(ageOverridden, setting.Project)
+			if *setting.HistoryMaxAge > 0 {
+				cutoff := now.Add(-time.Duration(*setting.HistoryMaxAge) * time.Second)
+				if err := remove(timeCondition(db.Unscoped().Where("project = ?", setting.Project), time.Time{}, cutoff)); err != nil {
+					return deleted, err
+				}
+			}
+		}
+		if setting.HistoryMaxRows != nil && *setting.HistoryMaxRows > 0 {
+			n, err := db.keepNewest(model, &setting.Project, *setting.HistoryMaxRows)
+			deleted += n
+			if err != nil {
+				return deleted, err
+			}
+		}
+	}
+
+	if global.MaxAge > 0 {
+		query := timeCondition(db.Unscoped(), time.Time{}, now.Add(-global.MaxAge))
+		if len(ageOverridden) > 0 {
+			query = query.Where("project NOT IN ?", ageOverridden)
+		}
+		if err := remove(query); err != nil {
+			return deleted, err
+		}
+	}
+	if global.MaxRows > 0 {
+		n, err := db.keepNewest(model, nil, global.MaxRows)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// keepNewest 只保留最新的 n 条记录，project 不为 nil 时只清理该工程的记录。
+func (db *DB) keepNewest(model interface{}, project *string, n int) (int64, error) {
+	query := db.Unscoped().Model(model)
+	if project != nil {
+		query = query.Where("project = ?", *project)
+	}
+	var ids []uint
+	if err := query.Order("id desc").Offset(n).Limit(1).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	del := db.Unscoped().Where("id <= ?", ids[0])
+	if project != nil {
+		del = del.Where("project = ?", *project)
+	}
+	res := del.Delete(model)
+	return res.RowsAffected, res.Error
+}
+
+// PurgeEvents 物理删除符合条件的 HTTP 历史记录，条件为空时删除全部记录。
+func (db *DB) PurgeEvents(filter EventFilter) (int64, error) {
+	res := filter.apply(db.purgeSession().Model(&Event{})).Delete(&Event{})
+	return res.RowsAffected, res.Error
+}
+
+// PurgeSshEvents 物理删除符合条件的 SSH 历史记录，条件为空时删除全部记录。
+func (db *DB) PurgeSshEvents(filter SshEventFilter) (int64, error) {
+	res := filter.apply(db.purgeSession().Model(&SshEvent{})).Delete(&SshEvent{})
+	return res.RowsAffected, res.Error
+}
+
+// purgeSession 返回允许不带条件删除的会话，是否允许清空由调用方确认。
+func (db *DB) purgeSession() *gorm.DB {
+	return db.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped()
+}
+
+// Vacuum 整理数据库文件，回收已删除记录占用的空间。
+func (db *DB) Vacuum() error {
+	return db.Exec("VACUUM").Error
+}
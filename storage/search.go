@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 搜索结果摘要中标记命中词的前后缀
+const (
+	HighlightStart = "<mark>"
+	HighlightEnd   = "</mark>"
+)
+
+// snippetTokens 是摘要最多包含的词数。
+const snippetTokens = 16
+
+// ErrInvalidSearch 表示全文检索表达式不符合 FTS5 语法。
+var ErrInvalidSearch = errors.New("invalid search query")
+
+// ftsTable 描述一张历史表对应的 FTS5 外部内容索引。
+type ftsTable struct {
+	table   string
+	columns []string
+}
+
+var (
+	eventsFTS    = ftsTable{table: "events", columns: []string{"endpoint", "payload", "response_body"}}
+	sshEventsFTS = ftsTable{table: "ssh_events", columns: []string{"command", "response_body"}}
+)
+
+func (t ftsTable) name() string {
+	return t.table + "_fts"
+}
+
+// setup 创建索引表与同步触发器；索引表是新建的时从历史表重建索引。
+func (t ftsTable) setup(db *gorm.DB) error {
+	var exists int64
+	if err := db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", t.name()).Scan(&exists).Error; err != nil {
+		return err
+	}
+	cols := strings.Join(t.columns, ", ")
+	newCols := "new." + strings.Join(t.columns, ", new.")
+	oldCols := "old." + strings.Join(t.columns, ", old.")
+	statements := []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(%s, content='%s', content_rowid='id')", t.name(), cols, t.table),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]s_ai AFTER INSERT ON %[2]s BEGIN INSERT INTO %[1]s(rowid, %[3]s) VALUES (new.id, %[4]s); END",
+			t.name(), t.table, cols, newCols),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]s_ad AFTER DELETE ON %[2]s BEGIN INSERT INTO %[1]s(%[1]s, rowid, %[3]s) VALUES ('delete', old.id, %[4]s); END",
+			t.name(), t.table, cols, oldCols),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]s_au AFTER UPDATE OF %[3]s ON %[2]s BEGIN INSERT INTO %[1]s(%[1]s, rowid, %[3]s) VALUES ('delete', old.id, %[4]s); INSERT INTO %[1]s(rowid, %[3]s) VALUES (new.id, %[5]s); END",
+			t.name(), t.table, cols, oldCols, newCols),
+	}
+	if exists == 0 {
+		statements = append(statements, fmt.Sprintf("INSERT INTO %[1]s(%[1]s) VALUES ('rebuild')", t.name()))
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchCondition 返回按全文检索表达式过滤历史表的条件。
+func (t ftsTable) matchCondition(query *gorm.DB, match string) *gorm.DB {
+	return query.Where(fmt.Sprintf("%s.id IN (SELECT rowid FROM %s WHERE %s MATCH ?)", t.table, t.name(), t.name()), match)
+}
+
+// validate 检查全文检索表达式的语法。
+func (t ftsTable) validate(db *gorm.DB, match string) error {
+	var ids []int64
+	err := db.Raw(fmt.Sprintf("SELECT rowid FROM %s WHERE %s MATCH ? LIMIT 1", t.name(), t.name()), match).Scan(&ids).Error
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+	}
+	return nil
+}
+
+// snippets 返回各记录中最匹配的片段，命中词以 HighlightStart/HighlightEnd 包围。
+func (t ftsTable) snippets(db *gorm.DB, match string, ids []uint) (map[uint]string, error) {
+	result := make(map[uint]string, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		ID      uint
+		Snippet string
+	}
+	err := db.Raw(fmt.Sprintf("SELECT rowid AS id, snippet(%s, -1, ?, ?, '…', ?) AS snippet FROM %s WHERE %s MATCH ? AND rowid IN ?", t.name(), t.name(), t.name()),
+		HighlightStart, HighlightEnd, snippetTokens, match, ids).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.ID] = row.Snippet
+	}
+	return result, nil
+}
+
+// likeSnippet 为按子串匹配的搜索生成与全文检索相同格式的摘要，未命中时返回空字符串。
+func likeSnippet(term string, texts ...string) string {
+	const context = 30
+	for _, text := range texts {
+		i := indexFold(text, term)
+		if i < 0 {
+			continue
+		}
+		start, end := i, i+len(term)
+		from, to := []rune(text[:start]), []rune(text[end:])
+		prefix, suffix := "", ""
+		if len(from) > context {
+			from, prefix = from[len(from)-context:], "…"
+		}
+		if len(to) > context {
+			to, suffix = to[:context], "…"
+		}
+		return prefix + string(from) + HighlightStart + text[start:end] + HighlightEnd + string(to) + suffix
+	}
+	return ""
+}
+
+// indexFold 忽略 ASCII 大小写查找子串，与 SQLite 的 LIKE 一致。
+func indexFold(s, substr string) int {
+	lower, lowerSub := strings.ToLower(s), strings.ToLower(substr)
+	if len(lower) != len(s) || len(lowerSub) != len(substr) {
+		return strings.Index(s, substr)
+	}
+	return strings.Index(lower, lowerSub)
+}
+
+// fillEventSnippets 为搜索结果填充命中片段。
+func (db *DB) fillEventSnippets(filter EventFilter, events []Event) error {
+	// 同时指定时优先显示全文检索命中的片段
+	if filter.Query == "" {
+		if filter.Search != "" {
+			for i, e := range events {
+				events[i].Snippet = likeSnippet(filter.Search, e.Endpoint, e.Payload, e.ResponseBody)
+			}
+		}
+		return nil
+	}
+	ids := make([]uint, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	snippets, err := eventsFTS.snippets(db.DB, filter.Query, ids)
+	if err != nil {
+		return err
+	}
+	for i, e := range events {
+		events[i].Snippet = snippets[e.ID]
+	}
+	return nil
+}
+
+// fillSshEventSnippets 为 SSH 搜索结果填充命中片段。
+func (db *DB) fillSshEventSnippets(filter SshEventFilter, events []SshEvent) error {
+	// 同时指定时优先显示全文检索命中的片段
+	if filter.Query == "" {
+		if filter.Search != "" {
+			for i, e := range events {
+				events[i].Snippet = likeSnippet(filter.Search, e.Command, e.ResponseBody)
+			}
+		}
+		return nil
+	}
+	ids := make([]uint, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	snippets, err := sshEventsFTS.snippets(db.DB, filter.Query, ids)
+	if err != nil {
+		return err
+	}
+	for i, e := range events {
+		events[i].Snippet = snippets[e.ID]
+	}
+	return nil
+}
+
+// ValidateEventSearch 检查 HTTP 历史记录的全文检索表达式，语法错误时返回 ErrInvalidSearch。
+func (db *DB) ValidateEventSearch(query string) error {
+	return eventsFTS.validate(db.DB, query)
+}
+
+// ValidateSshEventSearch 检查 SSH 历史记录的全文检索表达式，语法错误时返回 ErrInvalidSearch。
+func (db *DB) ValidateSshEventSearch(query string) error {
+	return sshEventsFTS.validate(db.DB, query)
+}
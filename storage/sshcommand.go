@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// SSH 命令的四种形式，匹配前命令中连续的空白统一为一个空格并去掉首尾空白：
+//   - 精确命令：show version
+//   - 参数命令：ping {host}、show interface {name...}，{name} 匹配一个词并捕获为参数，
+//     {name...} 只能作为最后一个词并捕获剩余的所有词，单独的 * 匹配任意一个词
+//   - 通配命令：show running-config *，* 匹配任意文本（可以为空），结尾的 " *" 可以省略，
+//     因此也匹配 show running-config；注意参数命令中单独的 * 只匹配一个词，ping {host} * 不匹配 ping
+//   - 正则命令：以 ~ 开头，如 ~^ping (?P<host>\S+)( count (?P<count>\d+))?$，具名分组捕获为参数
+const (
+	sshCommandExact = iota
+	sshCommandTemplate
+	sshCommandGlob
+	sshCommandRegex
+)
+
+var sshRestParamRe = regexp.MustCompile(`^\{([A-Za-z_][A-Za-z0-9_]*)\.\.\.\}$`)
+
+// SshCommandPattern 是编译后的 SSH 命令。
+type SshCommandPattern struct {
+	Raw     string
+	kind    int
+	re      *regexp.Regexp
+	literal int // 字面字符数，越多越具体
+}
+
+// NormalizeSshCommand 把命令中连续的空白统一为一个空格并去掉首尾空白。
+func NormalizeSshCommand(command string) string {
+	return strings.Join(strings.Fields(command), " ")
+}
+
+// IsSshCommandPattern 判断命令是否为参数、通配或正则形式。
+func IsSshCommandPattern(command string) bool {
+	return strings.HasPrefix(command, "~") || strings.ContainsAny(command, "{*")
+}
+
+// ParseSshCommandPattern 编译 SSH 命令，非法的参数命令或正则返回错误。除正则外，命令先按 NormalizeSshCommand 规范化。
+func ParseSshCommandPattern(command string) (*SshCommandPattern, error) {
+	if strings.HasPrefix(command, "~") {
+		re, err := regexp.Compile(command[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid command regex: %v", err)
+		}
+		return &SshCommandPattern{Raw: command, kind: sshCommandRegex, re: re}, nil
+	}
+	command = NormalizeSshCommand(command)
+	if command == "" {
+		return nil, fmt.Errorf("command cannot be empty")
+	}
+	switch {
+	case strings.Contains(command, "{"):
+		return parseSshCommandTemplate(command)
+	case strings.Contains(command, "*"):
+		// 结尾的 " *" 可以省略，show running-config * 也匹配 show running-config
+		glob, suffix := command, "$"
+		if strings.HasSuffix(glob, " *") {
+			glob, suffix = strings.TrimSuffix(glob, " *"), "( .*)?$"
+		}
+		parts := strings.Split(glob, "*")
+		literal := 0
+		for i, part := range parts {
+			literal += len(part)
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		re := regexp.MustCompile("^" + strings.Join(parts, ".*") + suffix)
+		return &SshCommandPattern{Raw: command, kind: sshCommandGlob, re: re, literal: literal}, nil
+	}
+	return &SshCommandPattern{Raw: command, kind: sshCommandExact, literal: len(command)}, nil
+}
+
+func parseSshCommandTemplate(command string) (*SshCommandPattern, error) {
+	words := strings.Split(command, " ")
+	var expr strings.Builder
+	expr.WriteString("^")
+	literal := 0
+	seen := make(map[string]bool)
+	addName := func(name string) error {
+		if seen[name] {
+			return fmt.Errorf("duplicate argument %q", name)
+		}
+		seen[name] = true
+		return nil
+	}
+	for i, word := range words {
+		if i > 0 {
+			expr.WriteString(" ")
+		}
+		if m := sshRestParamRe.FindStringSubmatch(word); m != nil {
+			if i != len(words)-1 {
+				return nil, fmt.Errorf("%q is only allowed as the last word", word)
+			}
+			if err := addName(m[1]); err != nil {
+				return nil, err
+			}
+			expr.WriteString("(?P<" + m[1] + ">.+)")
+			continue
+		}
+		if word == "*" {
+			expr.WriteString(`\S+`)
+			continue
+		}
+		matches := templateParamRe.FindAllStringSubmatchIndex(word, -1)
+		last := 0
+		for _, m := range matches {
+			name := word[m[2]:m[3]]
+			if err := addName(name); err != nil {
+				return nil, err
+			}
+			if err := writeSshLiteral(&expr, word[last:m[0]]); err != nil {
+				return nil, err
+			}
+			literal += m[0] - last
+			expr.WriteString("(?P<" + name + `>\S+?)`)
+			last = m[1]
+		}
+		if err := writeSshLiteral(&expr, word[last:]); err != nil {
+			return nil, err
+		}
+		literal += len(word) - last
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid command template: %v", err)
+	}
+	return &SshCommandPattern{Raw: command, kind: sshCommandTemplate, re: re, literal: literal}, nil
+}
+
+func writeSshLiteral(expr *strings.Builder, literal string) error {
+	if strings.ContainsAny(literal, "{}") {
+		return fmt.Errorf("invalid argument near %q", literal)
+	}
+	if strings.Contains(literal, "*") {
+		return fmt.Errorf("'*' must be a whole word in %q", literal)
+	}
+	expr.WriteString(regexp.QuoteMeta(literal))
+	return nil
+}
+
+// Match 判断规范化后的命令是否匹配，并返回捕获的参数。
+func (p *SshCommandPattern) Match(command string) (map[string]string, bool) {
+	if p.kind == sshCommandExact {
+		return nil, p.Raw == command
+	}
+	m := p.re.FindStringSubmatch(command)
+	if m == nil {
+		return nil, false
+	}
+	args := make(map[string]string)
+	for i, name := range p.re.SubexpNames() {
+		if name != "" && i < len(m) {
+			args[name] = m[i]
+		}
+	}
+	return args, true
+}
+
+type sshConfigCandidate struct {
+	config  SshConfig
+	pattern *SshCommandPattern
+	args    map[string]string
+}
+
+// sortSshCandidates 按“优先级 > 形式（参数 > 通配 > 正则） > 字面字符数（正则为表达式长度） > 指定模式 > 创建顺序”排序。
+func sortSshCandidates(candidates []sshConfigCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.config.Priority != b.config.Priority {
+			return a.config.Priority > b.config.Priority
+		}
+		if a.pattern.kind != b.pattern.kind {
+			return a.pattern.kind < b.pattern.kind
+		}
+		if a.pattern.kind == sshCommandRegex && len(a.pattern.Raw) != len(b.pattern.Raw) {
+			return len(a.pattern.Raw) > len(b.pattern.Raw)
+		}
+		if a.pattern.literal != b.pattern.literal {
+			return a.pattern.literal > b.pattern.literal
+		}
+		if (a.config.Mode == "") != (b.config.Mode == "") {
+			return a.config.Mode != ""
+		}
+		return a.config.ID < b.config.ID
+	})
+}
+
+// GetSshConfigForCommand 返回在 mode 模式下执行的命令对应的配置以及捕获的参数，没有时返回 nil。
+// 只考虑该模式与任意模式的配置；精确命令总是优先（指定模式者优先），其次按 sortSshCandidates 的顺序选择匹配的参数、通配与正则命令。
+func (db *DB) GetSshConfigForCommand(command, mode string) (*SshConfig, map[string]string, error) {
+	command = NormalizeSshCommand(command)
+	modes := []string{mode, ""}
+	var config SshConfig
+	err := db.Where("command = ? AND mode IN ?", command, modes).Order("mode = '' asc").First(&config).Error
+	if err == nil {
+		return &config, nil, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, nil, err
+	}
+
+	var patterns []SshConfig
+	err = db.Where("(command LIKE ? OR command LIKE ? OR command LIKE ?) AND mode IN ?", "~%", "%{%", "%*%", modes).Find(&patterns).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	var candidates []sshConfigCandidate
+	for _, cfg := range patterns {
+		pattern, err := ParseSshCommandPattern(cfg.Command)
+		if err != nil {
+			log.Printf("storage: Skipping SSH config %d with invalid command %q: %v", cfg.ID, cfg.Command, err)
+			continue
+		}
+		if args, ok := pattern.Match(command); ok {
+			candidates = append(candidates, sshConfigCandidate{config: cfg, pattern: pattern, args: args})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil, nil
+	}
+	sortSshCandidates(candidates)
+	return &candidates[0].config, candidates[0].args, nil
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestSshCommandPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		command string
+		want    bool
+		args    map[string]string
+	}{
+		{"show version", "show version", true, nil},
+		{"show  version ", "show version", true, nil},
+		{"show version", "show versions", false, nil},
+		{"ping {host}", "ping 10.0.0.1", true, map[string]string{"host": "10.0.0.1"}},
+		{"ping {host}", "ping", false, nil},
+		{"ping {host}", "ping 10.0.0.1 count 3", false, nil},
+		{"ping {host} *", "ping 10.0.0.1 count", true, map[string]string{"host": "10.0.0.1"}},
+		{"ping {host} *", "ping 10.0.0.1", false, nil},
+		{"ping {host} *", "ping 10.0.0.1 count 3", false, nil},
+		{"show interface {name...}", "show interface GigabitEthernet 0/1", true, map[string]string{"name": "GigabitEthernet 0/1"}},
+		{"show interface {name...}", "show interface", false, nil},
+		{"vlan{id}", "vlan10", true, map[string]string{"id": "10"}},
+		{"show running-config *", "show running-config", true, nil},
+		{"show running-config *", "show running-config interface Gi0/1", true, nil},
+		{"show running-config *", "show running-configuration", false, nil},
+		{"show *-config", "show running-config", true, nil},
+		{"show *-config", "show running-config all", false, nil},
+		{"*", "anything at all", true, nil},
+		{`~^ping (?P<host>\S+)( count (?P<count>\d+))?$`, "ping 10.0.0.1 count 3", true, map[string]string{"host": "10.0.0.1", "count": "3"}},
+		{`~^ping (?P<host>\S+)( count (?P<count>\d+))?$`, "ping 10.0.0.1", true, map[string]string{"host": "10.0.0.1", "count": ""}},
+		{`~^ping (?P<host>\S+)$`, "traceroute 10.0.0.1", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.command, func(t *testing.T) {
+			p, err := ParseSshCommandPattern(tt.pattern)
+			if err != nil {
+				t.Fatalf("ParseSshCommandPattern: %v", err)
+			}
+			args, ok := p.Match(tt.command)
+			if ok != tt.want {
+				t.Fatalf("Match = %v, want %v", ok, tt.want)
+			}
+			if ok && tt.args != nil && !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParseSshCommandPatternErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		"   ",
+		"show interface {name...} detail",
+		"copy {file} {file}",
+		"ping {host",
+		"ping {host}*",
+		"~^ping (",
+	} {
+		if _, err := ParseSshCommandPattern(pattern); err == nil {
+			t.Errorf("ParseSshCommandPattern(%q) succeeded, want error", pattern)
+		}
+	}
+}
+
+func TestGetSshConfigForCommand(t *testing.T) {
+	db := newTestDB(t)
+	for _, cfg := range []SshConfig{
+		{Command: "show version", Response: "exact any"},
+		{Command: "show version", Mode: "config", Response: "exact config"},
+		{Command: "show *", Response: "glob"},
+		{Command: "show {what}", Response: "template"},
+		{Command: "show interface {name...}", Response: "long template"},
+		{Command: `~^show .*$`, Response: "regex"},
+		{Command: `~^reload$`, Priority: 10, Response: "regex first"},
+		{Command: "reload *", Mode: "config", Response: "config only"},
+	} {
+		cfg := cfg
+		if err := db.SetSshConfig(&cfg); err != nil {
+			t.Fatalf("SetSshConfig(%q): %v", cfg.Command, err)
+		}
+	}
+
+	tests := []struct {
+		command, mode string
+		want          string
+	}{
+		{"show version", "", "exact any"},
+		{"show  version", "config", "exact config"},
+		{"show version", "enable", "exact any"},
+		{"show clock", "", "template"},
+		{"show clock detail", "", "glob"},
+		{"show interface Gi0/1", "", "long template"},
+		{"reload", "", "regex first"},
+		{"reload now", "config", "config only"},
+		{"reload now", "enable", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command+"@"+tt.mode, func(t *testing.T) {
+			cfg, _, err := db.GetSshConfigForCommand(tt.command, tt.mode)
+			if err != nil {
+				t.Fatalf("GetSshConfigForCommand: %v", err)
+			}
+			got := ""
+			if cfg != nil {
+				got = cfg.Response
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortSshCandidates(t *testing.T) {
+	parse := func(command string) *SshCommandPattern {
+		p, err := ParseSshCommandPattern(command)
+		if err != nil {
+			t.Fatalf("ParseSshCommandPattern(%q): %v", command, err)
+		}
+		return p
+	}
+	candidates := []sshConfigCandidate{
+		{config: SshConfig{Model: gorm.Model{ID: 1}, Command: `~^show .*$`}},
+		{config: SshConfig{Model: gorm.Model{ID: 2}, Command: "show *"}},
+		{config: SshConfig{Model: gorm.Model{ID: 3}, Command: "show {what}"}},
+		{config: SshConfig{Model: gorm.Model{ID: 4}, Command: "show inter{x}"}},
+		{config: SshConfig{Model: gorm.Model{ID: 5}, Command: "show {what}", Mode: "enable"}},
+		{config: SshConfig{Model: gorm.Model{ID: 6}, Command: `~^show (clock|version)$`}},
+		{config: SshConfig{Model: gorm.Model{ID: 7}, Command: "show *", Priority: 1}},
+	}
+	for i := range candidates {
+		candidates[i].pattern = parse(candidates[i].config.Command)
+	}
+	sortSshCandidates(candidates)
+	var got []uint
+	for _, c := range candidates {
+		got = append(got, c.config.ID)
+	}
+	want := []uint{7, 4, 5, 3, 2, 6, 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SshProfile 是网络设备命令行的模拟配置：登录后显示的横幅、各模式下的提示符，以及在模式之间切换的命令。
+// 用户指定了 Profile 时使用该配置，否则使用 IsDefault 为 true 的配置；都没有时使用普通 shell。
+type SshProfile struct {
+	gorm.Model
+	Name        string          `gorm:"uniqueIndex"`
+	Hostname    string          // 提示符与模板中的主机名
+	Banner      string          // 交互式 shell 登录后显示的内容
+	InitialMode string          // 登录后所在的模式，单条命令（exec）也在该模式下执行
+	Modes       []SshMode       `gorm:"serializer:json"`
+	Transitions []SshTransition `gorm:"serializer:json"`
+	IsDefault   bool            `gorm:"index"` // 未指定 Profile 的用户使用的配置，最多一个
+	Remark      string
+}
+
+// SshMode 是设备命令行的一个模式，Prompt 中的 {hostname} 替换为主机名，如 "{hostname}(config)#"。
+type SshMode struct {
+	Name   string
+	Prompt string
+}
+
+// SshTransition 是在模式之间切换的命令。From 为空表示任意模式；Output 为切换时显示的内容（可选）。
+type SshTransition struct {
+	From    string
+	Command string // 按 NormalizeSshCommand 规范化后精确匹配
+	To      string
+	Output  string
+}
+
+// Cisco IOS 风格的默认模式与切换命令，创建配置时未指定模式则使用这些值。
+const (
+	SshModeUser       = "user"
+	SshModePrivileged = "privileged"
+	SshModeConfig     = "config"
+)
+
+// DefaultSshModes 返回 Cisco IOS 风格的用户、特权与配置模式。
+func DefaultSshModes() []SshMode {
+	return []SshMode{
+		{Name: SshModeUser, Prompt: "{hostname}>"},
+		{Name: SshModePrivileged, Prompt: "{hostname}#"},
+		{Name: SshModeConfig, Prompt: "{hostname}(config)#"},
+	}
+}
+
+// DefaultSshTransitions 返回在默认模式之间切换的 enable、disable、configure terminal、end 与 exit。
+func DefaultSshTransitions() []SshTransition {
+	return []SshTransition{
+		{From: SshModeUser, Command: "enable", To: SshModePrivileged},
+		{From: SshModePrivileged, Command: "disable", To: SshModeUser},
+		{From: SshModePrivileged, Command: "configure terminal", To: SshModeConfig, Output: "Enter configuration commands, one per line.  End with CNTL/Z."},
+		{From: SshModePrivileged, Command: "conf t", To: SshModeConfig, Output: "Enter configuration commands, one per line.  End with CNTL/Z."},
+		{From: SshModeConfig, Command: "end", To: SshModePrivileged},
+		{From: SshModeConfig, Command: "exit", To: SshModePrivileged},
+	}
+}
+
+// HasMode 判断配置是否定义了该模式。
+func (p *SshProfile) HasMode(name string) bool {
+	for _, m := range p.Modes {
+		if m.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Prompt 返回模式的提示符，未定义的模式返回 "> "。
+func (p *SshProfile) Prompt(mode string) string {
+	for _, m := range p.Modes {
+		if m.Name == mode {
+			return strings.ReplaceAll(m.Prompt, "{hostname}", p.Hostname)
+		}
+	}
+	return "> "
+}
+
+// Transition 返回在 mode 下执行 command 触发的模式切换，没有时返回 nil。指定 From 的切换优先于任意模式的切换。
+func (p *SshProfile) Transition(mode, command string) *SshTransition {
+	command = NormalizeSshCommand(command)
+	var fallback *SshTransition
+	for i := range p.Transitions {
+		t := &p.Transitions[i]
+		if NormalizeSshCommand(t.Command) != command {
+			continue
+		}
+		if t.From == mode {
+			return t
+		}
+		if t.From == "" && fallback == nil {
+			fallback = t
+		}
+	}
+	return fallback
+}
+
+// GetAllSshProfiles 返回所有设备配置。
+func (db *DB) GetAllSshProfiles() ([]SshProfile, error) {
+	var profiles []SshProfile
+	err := db.Order("name").Find(&profiles).Error
+	return profiles, err
+}
+
+// GetSshProfile 按名称返回设备配置，不存在时返回 nil。
+func (db *DB) GetSshProfile(name string) (*SshProfile, error) {
+	var profile SshProfile
+	err := db.Where("name = ?", name).First(&profile).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// GetSshProfileForUser 返回用户登录后使用的设备配置：用户指定的配置，未指定或已删除时为默认配置，都没有时返回 nil。
+func (db *DB) GetSshProfileForUser(user *SshUser) (*SshProfile, error) {
+	if user != nil && user.Profile != "" {
+		if profile, err := db.GetSshProfile(user.Profile); err != nil || profile != nil {
+			return profile, err
+		}
+	}
+	var profile SshProfile
+	err := db.Where("is_default = ?", true).First(&profile).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// SetSshProfile 按名称创建或整体更新设备配置；设为默认配置时取消其他配置的默认标记。
+func (db *DB) SetSshProfile(profile *SshProfile) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if profile.IsDefault {
+			if err := tx.Model(&SshProfile{}).Where("name <> ? AND is_default = ?", profile.Name, true).Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"hostname", "banner", "initial_mode", "modes", "transitions", "is_default", "remark", "updated_at", "deleted_at"}),
+		}).Create(profile).Error
+	})
+}
+
+// DeleteSshProfile 删除设备配置。
+func (db *DB) DeleteSshProfile(name string) error {
+	return db.Where("name = ?", name).Delete(&SshProfile{}).Error
+}
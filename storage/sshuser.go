@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SshUser 是 SSH 模拟服务的登录用户。用户可以用密码、已授权的公钥或 keyboard-interactive 质询登录，
+// 登录后执行的命令记入 Project 工程。
+type SshUser struct {
+	gorm.Model
+	Username              string         `gorm:"uniqueIndex"`
+	PasswordHash          string         `json:"-"`                        // bcrypt 哈希，为空表示不允许密码登录
+	HasPassword           bool           `gorm:"-"`                        // 是否设置了密码，只用于展示
+	AuthorizedKeys        []string       `gorm:"serializer:json"`          // authorized_keys 格式的公钥
+	Challenges            []SshChallenge `gorm:"serializer:json"`          // keyboard-interactive 登录时依次提出的质询
+	ChallengeAnswerHashes []string       `gorm:"serializer:json" json:"-"` // 与 Challenges 一一对应的回答的 bcrypt 哈希
+	Project               string         `gorm:"index"`                    // 为空时沿用命令配置的工程
+	Profile               string         // 登录后使用的设备配置，为空时使用默认配置，见 SshProfile
+	Disabled              bool
+}
+
+// SshChallenge 是 keyboard-interactive 登录时的一个质询，如一次性验证码。回答与密码一样只保存哈希，见 SetChallenges。
+type SshChallenge struct {
+	Prompt string
+	Echo   bool // 客户端是否回显输入
+}
+
+// AfterFind 根据密码哈希填充 HasPassword。
+func (u *SshUser) AfterFind(tx *gorm.DB) error {
+	u.HasPassword = u.PasswordHash != ""
+	return nil
+}
+
+// SetPassword 保存密码的哈希，password 为空时清除密码。
+func (u *SshUser) SetPassword(password string) error {
+	if password == "" {
+		u.PasswordHash = ""
+		return nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+// SetChallenges 保存质询及其回答，answerHashes 与 challenges 一一对应，为空字符串的位置用 answers 中的回答生成哈希。
+func (u *SshUser) SetChallenges(challenges []SshChallenge, answers, answerHashes []string) error {
+	hashes := make([]string, len(challenges))
+	for i := range challenges {
+		if answerHashes[i] != "" {
+			hashes[i] = answerHashes[i]
+			continue
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(answers[i]), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		hashes[i] = string(hash)
+	}
+	u.Challenges = challenges
+	u.ChallengeAnswerHashes = hashes
+	return nil
+}
+
+// CheckChallengeAnswer 判断第 i 个质询的回答是否正确。
+func (u *SshUser) CheckChallengeAnswer(i int, answer string) bool {
+	if i >= len(u.ChallengeAnswerHashes) {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.ChallengeAnswerHashes[i]), []byte(answer)) == nil
+}
+
+// ChallengeAnswerHash 返回提问为 prompt 的质询的回答哈希，没有该质询时返回空字符串。
+func (u *SshUser) ChallengeAnswerHash(prompt string) string {
+	for i, ch := range u.Challenges {
+		if ch.Prompt == prompt && i < len(u.ChallengeAnswerHashes) {
+			return u.ChallengeAnswerHashes[i]
+		}
+	}
+	return ""
+}
+
+// CheckPassword 判断密码是否正确，未设置密码时总是返回 false。
+func (u *SshUser) CheckPassword(password string) bool {
+	if u.PasswordHash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// GetAllSshUsers 返回所有 SSH 用户。
+func (db *DB) GetAllSshUsers() ([]SshUser, error) {
+	var users []SshUser
+	err := db.Order("username").Find(&users).Error
+	return users, err
+}
+
+// GetSshUser 按用户名返回 SSH 用户，不存在时返回 nil。
+func (db *DB) GetSshUser(username string) (*SshUser, error) {
+	var user SshUser
+	err := db.Where("username = ?", username).First(&user).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetSshUser 按用户名创建或整体更新 SSH 用户。
+func (db *DB) SetSshUser(user *SshUser) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "username"}},
+		DoUpdates: clause.AssignmentColumns([]string{"password_hash", "authorized_keys", "challenges", "challenge_answer_hashes", "project", "profile", "disabled", "updated_at", "deleted_at"}),
+	}).Create(user).Error
+}
+
+// DeleteSshUser 删除 SSH 用户。
+func (db *DB) DeleteSshUser(username string) error {
+	return db.Where("username = ?", username).Delete(&SshUser{}).Error
+}
@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidStats 表示统计的分组字段或时间粒度不合法。
+var ErrInvalidStats = errors.New("invalid stats grouping")
+
+// StatsRequest 描述统计结果的分组方式，两者都为空时只返回总计。
+type StatsRequest struct {
+	GroupBy []string // 分组字段
+	Bucket  string   // 时间粒度：minute、hour 或 day，为空时不按时间分组
+}
+
+// Stats 是一组历史记录的统计结果。
+type Stats struct {
+	Key                map[string]string `json:"key,omitempty"` // 分组字段的取值，按时间分组时包含 bucket（该时间段的起点）
+	Count              int64             `json:"count"`
+	AutoResponded      int64             `json:"autoResponded"`      // 自动响应，包括挂起超时后投递默认响应
+	Custom             int64             `json:"custom"`             // 操作员自定义响应或拒绝
+	Cancelled          int64             `json:"cancelled"`          // 调用方在响应前断开
+	Other              int64             `json:"other"`              // 转发上游、重放、仍在等待等
+	Unmatched          int64             `json:"unmatched"`          // 未命中任何配置的请求数，SSH 记录不统计
+	UnmatchedEndpoints int64             `json:"unmatchedEndpoints"` // 未命中配置的请求涉及的不同 (method, endpoint, source) 数
+	Ratios             StatsRatios       `json:"ratios"`
+	Latency            *LatencyStats     `json:"latency,omitempty"` // 没有耗时数据时为 nil
+
+	latencies     []int64
+	unmatchedKeys map[string]struct{}
+}
+
+// StatsRatios 是各类记录占总数的比例。
+type StatsRatios struct {
+	AutoResponded float64 `json:"autoResponded"`
+	Custom        float64 `json:"custom"`
+	Cancelled     float64 `json:"cancelled"`
+	Unmatched     float64 `json:"unmatched"`
+}
+
+// LatencyStats 是已响应记录的耗时分布（毫秒）。
+type LatencyStats struct {
+	Min int64   `json:"min"`
+	Avg float64 `json:"avg"`
+	P50 int64   `json:"p50"`
+	P90 int64   `json:"p90"`
+	P95 int64   `json:"p95"`
+	P99 int64   `json:"p99"`
+	Max int64   `json:"max"`
+}
+
+// StatsReport 是统计接口的结果，Groups 按分组取值排序。
+type StatsReport struct {
+	Total  Stats   `json:"total"`
+	Groups []Stats `json:"groups,omitempty"`
+}
+
+// 可分组的字段及对应的列
+var (
+	eventStatsColumns = map[string]string{
+		"endpoint": "endpoint",
+		"project":  "project",
+		"source":   "source",
+		"method":   "method",
+	}
+	sshEventStatsColumns = map[string]string{
+		"command":  "command",
+		"project":  "project",
+		"username": "username",
+	}
+)
+
+// statsRow 是统计时读取的一条记录，只包含需要的列。
+type statsRow struct {
+	Timestamp time.Time
+	Endpoint  string
+	Project   string
+	Source    string
+	Method    string
+	Command   string
+	Username  string
+	Status    string
+	Unmatched bool
+	LatencyMs *int64
+}
+
+// EventStats 统计符合条件的 HTTP 历史记录。
+func (db *DB) EventStats(filter EventFilter, req StatsRequest) (StatsReport, error) {
+	agg, err := newStatsAggregator(req, eventStatsColumns)
+	if err != nil {
+		return StatsReport{}, err
+	}
+	query := filter.apply(db.Model(&Event{})).Select("timestamp, COALESCE(endpoint, '') AS endpoint, COALESCE(project, '') AS project, " +
+		"COALESCE(source, '') AS source, COALESCE(method, '') AS method, COALESCE(status, '') AS status, COALESCE(unmatched, false) AS unmatched, latency_ms")
+	err = scanStatsRows(query, func(row *statsRow) {
+		values := map[string]string{"endpoint": row.Endpoint, "project": row.Project, "source": row.Source, "method": row.Method}
+		latency := row.LatencyMs
+		// 挂起中或调用方已断开的记录没有完整的耗时
+		if row.Status == "Pending" || row.Status == "Cancelled" {
+			latency = nil
+		}
+		unmatchedKey := ""
+		if row.Unmatched {
+			unmatchedKey = row.Method + "\x00" + row.Endpoint + "\x00" + row.Source
+		}
+		agg.add(row.Timestamp, values, row.Status, unmatchedKey, latency)
+	})
+	if err != nil {
+		return StatsReport{}, err
+	}
+	return agg.report(), nil
+}
+
+// SshEventStats 统计符合条件的 SSH 历史记录，SSH 记录没有耗时数据。
+func (db *DB) SshEventStats(filter SshEventFilter, req StatsRequest) (StatsReport, error) {
+	agg, err := newStatsAggregator(req, sshEventStatsColumns)
+	if err != nil {
+		return StatsReport{}, err
+	}
+	query := filter.apply(db.Model(&SshEvent{})).Select("timestamp, COALESCE(command, '') AS command, COALESCE(project, '') AS project, " +
+		"COALESCE(username, '') AS username, COALESCE(status, '') AS status")
+	err = scanStatsRows(query, func(row *statsRow) {
+		agg.add(row.Timestamp, map[string]string{"command": row.Command, "project": row.Project, "username": row.Username}, row.Status, "", nil)
+	})
+	if err != nil {
+		return StatsReport{}, err
+	}
+	return agg.report(), nil
+}
+
+func scanStatsRows(query *gorm.DB, fn func(*statsRow)) error {
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row statsRow
+		if err := query.ScanRows(rows, &row); err != nil {
+			return err
+		}
+		fn(&row)
+	}
+	return rows.Err()
+}
+
+// statsAggregator 逐条累计记录，分组的数量通常远少于记录数。
+type statsAggregator struct {
+	req    StatsRequest
+	total  Stats
+	groups map[string]*Stats
+}
+
+func newStatsAggregator(req StatsRequest, columns map[string]string) (*statsAggregator, error) {
+	for _, field := range req.GroupBy {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("%w: unknown group field %q", ErrInvalidStats, field)
+		}
+	}
+	switch req.Bucket {
+	case "", "minute", "hour", "day":
+	default:
+		return nil, fmt.Errorf("%w: bucket must be minute, hour or day", ErrInvalidStats)
+	}
+	return &statsAggregator{req: req, groups: make(map[string]*Stats)}, nil
+}
+
+// add 累计一条记录，unmatchedKey 为未命中配置的请求的 (method, endpoint, source)，命中时为空。
+func (a *statsAggregator) add(ts time.Time, values map[string]string, status, unmatchedKey string, latency *int64) {
+	a.total.add(status, unmatchedKey, latency)
+	if len(a.req.GroupBy) == 0 && a.req.Bucket == "" {
+		return
+	}
+	key := make(map[string]string, len(a.req.GroupBy)+1)
+	parts := make([]string, 0, len(a.req.GroupBy)+1)
+	if a.req.Bucket != "" {
+		key["bucket"] = bucketStart(ts, a.req.Bucket).Format(time.RFC3339)
+		parts = append(parts, key["bucket"])
+	}
+	for _, field := range a.req.GroupBy {
+		key[field] = values[field]
+		parts = append(parts, values[field])
+	}
+	id := strings.Join(parts, "\x00")
+	group, ok := a.groups[id]
+	if !ok {
+		group = &Stats{Key: key}
+		a.groups[id] = group
+	}
+	group.add(status, unmatchedKey, latency)
+}
+
+func (a *statsAggregator) report() StatsReport {
+	report := StatsReport{Total: a.total}
+	report.Total.finish()
+	ids := make([]string, 0, len(a.groups))
+	for id := range a.groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		group := a.groups[id]
+		group.finish()
+		report.Groups = append(report.Groups, *group)
+	}
+	return report
+}
+
+func (s *Stats) add(status, unmatchedKey string, latency *int64) {
+	s.Count++
+	switch {
+	case strings.HasPrefix(status, "Auto-Responded"):
+		s.AutoResponded++
+	case status == "Responded (Custom)" || status == "Rejected":
+		s.Custom++
+	case status == "Cancelled":
+		s.Cancelled++
+	default:
+		s.Other++
+	}
+	if unmatchedKey != "" {
+		s.Unmatched++
+		if s.unmatchedKeys == nil {
+			s.unmatchedKeys = make(map[string]struct{})
+		}
+		s.unmatchedKeys[unmatchedKey] = struct{}{}
+	}
+	if latency != nil {
+		s.latencies = append(s.latencies, *latency)
+	}
+}
+
+// finish 计算比例、未命中的接口数与耗时分布。
+func (s *Stats) finish() {
+	s.UnmatchedEndpoints = int64(len(s.unmatchedKeys))
+	if s.Count > 0 {
+		n := float64(s.Count)
+		s.Ratios = StatsRatios{
+			AutoResponded: float64(s.AutoResponded) / n,
+			Custom:        float64(s.Custom) / n,
+			Cancelled:     float64(s.Cancelled) / n,
+			Unmatched:     float64(s.Unmatched) / n,
+		}
+	}
+	if len(s.latencies) == 0 {
+		return
+	}
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	var sum int64
+	for _, v := range s.latencies {
+		sum += v
+	}
+	s.Latency = &LatencyStats{
+		Min: s.latencies[0],
+		Avg: float64(sum) / float64(len(s.latencies)),
+		P50: percentile(s.latencies, 50),
+		P90: percentile(s.latencies, 90),
+		P95: percentile(s.latencies, 95),
+		P99: percentile(s.latencies, 99),
+		Max: s.latencies[len(s.latencies)-1],
+	}
+	s.latencies = nil
+}
+
+// percentile 按最近排名法返回已排序数据的百分位数。
+func percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// bucketStart 返回时间所在时间段的起点，按天分组时以本地时区的零点为界。
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.Local()
+	switch bucket {
+	case "minute":
+		return t.Truncate(time.Minute)
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventStatsCountsUnmatchedEndpoints(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	for i, e := range []Event{
+		{Method: "GET", Endpoint: "/a", Unmatched: true},
+		{Method: "GET", Endpoint: "/a", Unmatched: true},
+		{Method: "POST", Endpoint: "/a", Unmatched: true},
+		{Method: "GET", Endpoint: "/a", Source: "device-a", Unmatched: true},
+		{Method: "GET", Endpoint: "/b", Unmatched: true},
+		{Method: "GET", Endpoint: "/c"},
+	} {
+		e.RequestID = string(rune('a' + i))
+		e.Status = "Auto-Responded"
+		e.Timestamp = now
+		if err := db.CreateEvent(&e); err != nil {
+			t.Fatalf("CreateEvent: %v", err)
+		}
+	}
+
+	report, err := db.EventStats(EventFilter{}, StatsRequest{GroupBy: []string{"endpoint"}})
+	if err != nil {
+		t.Fatalf("EventStats: %v", err)
+	}
+	if report.Total.Unmatched != 5 || report.Total.UnmatchedEndpoints != 4 {
+		t.Fatalf("total: %d unmatched requests on %d endpoints, want 5 on 4", report.Total.Unmatched, report.Total.UnmatchedEndpoints)
+	}
+	want := map[string][2]int64{"/a": {4, 3}, "/b": {1, 1}, "/c": {0, 0}}
+	for _, g := range report.Groups {
+		w := want[g.Key["endpoint"]]
+		if g.Unmatched != w[0] || g.UnmatchedEndpoints != w[1] {
+			t.Errorf("%s: %d unmatched requests on %d endpoints, want %d on %d", g.Key["endpoint"], g.Unmatched, g.UnmatchedEndpoints, w[0], w[1])
+		}
+	}
+}
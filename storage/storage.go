@@ -2,6 +2,7 @@ package storage
 
 import (
 	"log"
+	"strings"
 	"time"
 
 	"github.com/glebarez/sqlite"
@@ -17,22 +18,73 @@ type ServiceInstance struct {
 	LastSeenAt   time.Time
 }
 
+// Config 以 (Method, Endpoint, Source) 唯一确定，Method 或 Source 为空表示匹配任意方法或任意设备。
 type Config struct {
 	gorm.Model
-	Endpoint        string `gorm:"uniqueIndex"`
+	Method          string `gorm:"uniqueIndex:idx_configs_key"`
+	Endpoint        string `gorm:"uniqueIndex:idx_configs_key"`
 	Project         string `gorm:"index"`
 	Remark          string
 	DefaultResponse string
-	Source          string         `gorm:"index"`
-	Rules           []ResponseRule `gorm:"foreignKey:ConfigID"`
+	Source          string `gorm:"index;uniqueIndex:idx_configs_key"`
+	HoldTimeout     *int   // 秒，nil 沿用工程或全局设置，HoldIndefinitely 表示一直等待操作员响应
+	ResponseSpec
+	Rules []ResponseRule `gorm:"foreignKey:ConfigID"`
 }
 
+// ResponseSpec 描述 mock 响应的状态码、响应头和内容类型，由 Config 与 ResponseRule 嵌入。
+type ResponseSpec struct {
+	StatusCode  int               // 0 表示 200
+	ContentType string            // 为空表示 DefaultContentType
+	Headers     map[string]string `gorm:"serializer:json"`
+}
+
+// DefaultContentType 是未指定内容类型时使用的默认值。
+const DefaultContentType = "application/xml; charset=utf-8"
+
 // ... (其他結構體保持不變)
 type ResponseRule struct {
 	gorm.Model
 	ConfigID uint
-	Keyword  string `gorm:"index"`
+	Priority int      `gorm:"index"` // 数值越小越先匹配，相同优先级按创建顺序
+	Keyword  string   `gorm:"index"`
+	Matcher  *Matcher `gorm:"serializer:json"` // 可选的结构化匹配条件，与 Keyword 同时存在时需同时满足
 	Response string
+	ResponseSpec
+}
+
+// 匹配器类型
+const (
+	MatcherContains = "contains" // 请求体包含 Value
+	MatcherRegex    = "regex"    // 请求体匹配正则 Expr
+	MatcherJSONPath = "jsonpath" // 请求体(JSON) 中 Expr 指向的值满足 Op/Value
+	MatcherXPath    = "xpath"    // 请求体(XML) 中 Expr 指向的值满足 Op/Value，前缀通过 Namespaces 解析
+	MatcherHeader   = "header"   // 请求头 Name 满足 Op/Value
+	MatcherQuery    = "query"    // 查询参数 Name 满足 Op/Value
+	MatcherPath     = "path"     // 接口路径捕获的路径变量 Name 满足 Op/Value
+	MatcherMethod   = "method"   // HTTP 方法等于 Value（忽略大小写）
+	MatcherAnd      = "and"      // 所有 Children 都命中
+	MatcherOr       = "or"       // 任一 Children 命中
+	MatcherNot      = "not"      // 唯一的 Children 未命中
+)
+
+// 取值类匹配器（jsonpath/xpath/header/query/path）的比较方式
+const (
+	MatchOpEquals   = "equals" // 默认
+	MatchOpContains = "contains"
+	MatchOpRegex    = "regex"
+	MatchOpExists   = "exists"
+)
+
+// Matcher 是规则的匹配条件树，以 JSON 形式保存在 ResponseRule 中。
+type Matcher struct {
+	Type       string            `json:"type"`
+	Expr       string            `json:"expr,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Op         string            `json:"op,omitempty"`
+	Value      string            `json:"value,omitempty"`
+	Namespaces map[string]string `json:"namespaces,omitempty"`
+	Children   []Matcher         `json:"children,omitempty"`
 }
 
 type Event struct {
@@ -45,14 +97,176 @@ type Event struct {
 	Status       string
 	Timestamp    time.Time
 	Source       string `gorm:"index"`
+	RuleID       uint   `gorm:"index"` // 产生响应的规则 ID，0 表示使用默认响应
+
+	Method          string              `gorm:"index"`
+	Query           string              // 原始查询字符串，不含 ?
+	RequestHeaders  map[string][]string `gorm:"serializer:json"`
+	ClientIP        string              `gorm:"index"` // 调用方地址，经从节点转发时为原始调用方
+	ConfigID        uint                `gorm:"index"` // 命中的配置 ID，0 表示未命中
+	Unmatched       bool                `gorm:"index"` // 未命中任何配置也没有上游服务，使用了全局默认响应
+	StatusCode      int                 `gorm:"index"` // 返回给调用方的状态码，尚未响应时为 0
+	ResponseHeaders map[string][]string `gorm:"serializer:json"`
+	LatencyMs       int64               // 从收到请求到写出响应的耗时（毫秒），包含等待人工响应的时间
+	ReplayOf        string              `gorm:"index"` // 重放时为原始记录的请求 ID
+	ReplayDiff      string              // 重放结果与原始响应的差异，为空表示一致
+
+	Snippet string `gorm:"-" json:",omitempty"` // 搜索时命中的片段，不保存
+}
+
+// EventFilter 是查询历史记录的过滤条件，零值字段不参与过滤。
+type EventFilter struct {
+	Project      string
+	Source       string
+	Search       string // 在路径、请求体与响应体中按子串搜索，不区分 ASCII 大小写
+	Query        string // FTS5 全文检索表达式，支持短语、前缀与布尔运算
+	Endpoint     string // 含 * 时按通配符匹配，如 /orders/*
+	Status       string // 逗号分隔的多个状态，按前缀匹配
+	Method       string
+	ClientIP     string
+	StatusCode   int
+	ConfigID     *uint
+	RuleID       *uint
+	Unmatched    *bool
+	MinLatencyMs int64
+	ReplayOf     string
+	Since        time.Time // 只包含不早于该时间的记录
+	Until        time.Time // 只包含早于该时间的记录
+}
+
+func (f EventFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Project != "" {
+		query = query.Where("project = ?", f.Project)
+	}
+	if f.Source != "" {
+		query = query.Where("source = ?", f.Source)
+	}
+	if f.Search != "" {
+		searchPattern := "%" + f.Search + "%"
+		query = query.Where("endpoint LIKE ? OR payload LIKE ? OR response_body LIKE ?", searchPattern, searchPattern, searchPattern)
+	}
+	if f.Query != "" {
+		query = eventsFTS.matchCondition(query, f.Query)
+	}
+	if f.Endpoint != "" {
+		query = wildcardCondition(query, "endpoint", f.Endpoint)
+	}
+	if f.Status != "" {
+		query = statusCondition(query, f.Status)
+	}
+	if f.Method != "" {
+		query = query.Where("method = ?", strings.ToUpper(f.Method))
+	}
+	if f.ClientIP != "" {
+		query = query.Where("client_ip = ?", f.ClientIP)
+	}
+	if f.StatusCode != 0 {
+		query = query.Where("status_code = ?", f.StatusCode)
+	}
+	if f.ConfigID != nil {
+		query = query.Where("config_id = ?", *f.ConfigID)
+	}
+	if f.RuleID != nil {
+		query = query.Where("rule_id = ?", *f.RuleID)
+	}
+	if f.Unmatched != nil {
+		query = query.Where("unmatched = ?", *f.Unmatched)
+	}
+	if f.MinLatencyMs > 0 {
+		query = query.Where("latency_ms >= ?", f.MinLatencyMs)
+	}
+	if f.ReplayOf != "" {
+		query = query.Where("replay_of = ?", f.ReplayOf)
+	}
+	return timeCondition(query, f.Since, f.Until)
+}
+
+// timeCondition 只保留时间在 [since, until) 之间的记录，零值表示不限制。
+// 时间列按写入时的时区偏移保存为文本（如 +08:00），服务器换了时区或经历夏令时后偏移会不同，
+// 文本不能直接比较，因此统一换算为儒略日（毫秒精度）再比较。
+func timeCondition(query *gorm.DB, since, until time.Time) *gorm.DB {
+	if !since.IsZero() {
+		query = query.Where("julianday(timestamp) >= julianday(?)", since)
+	}
+	if !until.IsZero() {
+		query = query.Where("julianday(timestamp) < julianday(?)", until)
+	}
+	return query
+}
+
+// likeEscaper 转义 LIKE 模式中的特殊字符，配合 ESCAPE '\' 使用。
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// wildcardCondition 按值过滤列，值中含 * 时作为通配符匹配任意字符。
+func wildcardCondition(query *gorm.DB, column, value string) *gorm.DB {
+	if !strings.Contains(value, "*") {
+		return query.Where(column+" = ?", value)
+	}
+	return query.Where(column+` LIKE ? ESCAPE '\'`, strings.ReplaceAll(likeEscaper.Replace(value), "*", "%"))
+}
+
+// statusCondition 按逗号分隔的多个状态前缀过滤，如 "Auto-Responded" 同时匹配带模板错误说明的状态。
+func statusCondition(query *gorm.DB, statuses string) *gorm.DB {
+	var conds []string
+	var args []interface{}
+	for _, status := range strings.Split(statuses, ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			conds = append(conds, `status LIKE ? ESCAPE '\'`)
+			args = append(args, likeEscaper.Replace(status)+"%")
+		}
+	}
+	if len(conds) == 0 {
+		return query
+	}
+	return query.Where(strings.Join(conds, " OR "), args...)
+}
+
+// SshEventFilter 是查询 SSH 历史记录的过滤条件，零值字段不参与过滤。
+type SshEventFilter struct {
+	Project  string
+	Search   string // 在命令与输出中按子串搜索，不区分 ASCII 大小写
+	Query    string // FTS5 全文检索表达式
+	Command  string // 含 * 时按通配符匹配
+	Status   string // 逗号分隔的多个状态，按前缀匹配
+	Username string
+	Since    time.Time
+	Until    time.Time
+}
+
+func (f SshEventFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Project != "" {
+		query = query.Where("project = ?", f.Project)
+	}
+	if f.Search != "" {
+		searchPattern := "%" + f.Search + "%"
+		query = query.Where("command LIKE ? OR response_body LIKE ?", searchPattern, searchPattern)
+	}
+	if f.Query != "" {
+		query = sshEventsFTS.matchCondition(query, f.Query)
+	}
+	if f.Command != "" {
+		query = wildcardCondition(query, "command", f.Command)
+	}
+	if f.Status != "" {
+		query = statusCondition(query, f.Status)
+	}
+	if f.Username != "" {
+		query = query.Where("username = ?", f.Username)
+	}
+	return timeCondition(query, f.Since, f.Until)
 }
 
 type SshConfig struct {
 	gorm.Model
-	Command  string `gorm:"uniqueIndex"`
-	Project  string `gorm:"index"`
-	Remark   string
-	Response string
+	Command     string `gorm:"uniqueIndex:idx_ssh_configs_key"`       // 精确、参数、通配或正则命令，见 ParseSshCommandPattern
+	Mode        string `gorm:"index;uniqueIndex:idx_ssh_configs_key"` // 设备命令行模式，为空表示任意模式，见 SshProfile
+	Priority    int    // 多个参数、通配或正则命令同时匹配时，数值大者优先
+	Project     string `gorm:"index"`
+	Remark      string
+	Response    string // 标准输出，可以是模板
+	Stderr      string // 标准错误输出，可以是模板
+	ExitCode    int    // 单条命令（exec）的退出码
+	HoldTimeout *int   // 秒，nil 沿用工程或全局设置，HoldIndefinitely 表示一直等待操作员响应
 }
 
 type SshEvent struct {
@@ -60,9 +274,15 @@ type SshEvent struct {
 	RequestID    string `gorm:"uniqueIndex"`
 	Command      string `gorm:"index"`
 	Project      string `gorm:"index"`
-	ResponseBody string
+	Username     string `gorm:"index"` // 登录的 SSH 用户名
+	Mode         string // 执行命令时所在的设备命令行模式
+	ResponseBody string // 标准输出
+	Stderr       string
+	ExitCode     *int `gorm:"index"` // 尚未响应时为 nil
 	Status       string
 	Timestamp    time.Time
+
+	Snippet string `gorm:"-" json:",omitempty"` // 搜索时命中的片段，不保存
 }
 
 type DB struct {
@@ -74,10 +294,37 @@ func NewDB(dsn string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = db.AutoMigrate(&Config{}, &Event{}, &ServiceInstance{}, &ResponseRule{}, &SshConfig{}, &SshEvent{})
+	// 旧版本按 endpoint 单独建立唯一索引，改为 (method, endpoint, source) 联合唯一前需要先删除
+	if db.Migrator().HasIndex(&Config{}, "idx_configs_endpoint") {
+		if err := db.Migrator().DropIndex(&Config{}, "idx_configs_endpoint"); err != nil {
+			return nil, err
+		}
+	}
+	// SSH 配置同样由按 command 唯一改为 (command, mode) 联合唯一
+	if db.Migrator().HasIndex(&SshConfig{}, "idx_ssh_configs_command") {
+		if err := db.Migrator().DropIndex(&SshConfig{}, "idx_ssh_configs_command"); err != nil {
+			return nil, err
+		}
+	}
+	err = db.AutoMigrate(&Config{}, &Event{}, &ServiceInstance{}, &ResponseRule{}, &SshConfig{}, &SshEvent{}, &ProjectSetting{}, &Upstream{}, &SshUser{}, &SshProfile{})
 	if err != nil {
 		return nil, err
 	}
+	// NULL 在唯一索引中互不相等，统一为空字符串以保证 upsert 能正确命中已有配置
+	if err := db.Model(&Config{}).Where("method IS NULL").UpdateColumn("method", "").Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&Config{}).Where("source IS NULL").UpdateColumn("source", "").Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&SshConfig{}).Where("mode IS NULL").UpdateColumn("mode", "").Error; err != nil {
+		return nil, err
+	}
+	for _, fts := range []ftsTable{eventsFTS, sshEventsFTS} {
+		if err := fts.setup(db); err != nil {
+			return nil, err
+		}
+	}
 	log.Println("Database connection successful and schema migrated.")
 	return &DB{db}, nil
 }
@@ -123,30 +370,36 @@ func (db *DB) GetServiceInstance(address string) (*ServiceInstance, error) {
 	return &instance, nil
 }
 
+// orderRules 让规则按匹配顺序返回：优先级升序，同优先级按 ID 升序。
+func orderRules(db *gorm.DB) *gorm.DB {
+	return db.Order("priority asc, id asc")
+}
+
 func (db *DB) GetRulesForConfig(configID uint) ([]ResponseRule, error) {
 	var rules []ResponseRule
-	err := db.Where("config_id = ?", configID).Find(&rules).Error
+	err := db.Where("config_id = ?", configID).Scopes(orderRules).Find(&rules).Error
 	return rules, err
 }
 
-func (db *DB) AddRuleToConfig(configID uint, keyword, response string) (ResponseRule, error) {
-	rule := ResponseRule{
-		ConfigID: configID,
-		Keyword:  keyword,
-		Response: response,
-	}
+func (db *DB) AddRuleToConfig(rule ResponseRule) (ResponseRule, error) {
 	err := db.Create(&rule).Error
 	return rule, err
 }
 
-func (db *DB) UpdateRule(ruleID uint, keyword, response string) (ResponseRule, error) {
+// UpdateRule 用 update 中的内容替换规则；priority 为 nil 时保留原有优先级。
+func (db *DB) UpdateRule(ruleID uint, priority *int, update ResponseRule) (ResponseRule, error) {
 	var rule ResponseRule
 	err := db.First(&rule, ruleID).Error
 	if err != nil {
 		return rule, err
 	}
-	rule.Keyword = keyword
-	rule.Response = response
+	if priority != nil {
+		rule.Priority = *priority
+	}
+	rule.Keyword = update.Keyword
+	rule.Matcher = update.Matcher
+	rule.Response = update.Response
+	rule.ResponseSpec = update.ResponseSpec
 	err = db.Save(&rule).Error
 	return rule, err
 }
@@ -155,30 +408,53 @@ func (db *DB) DeleteRule(ruleID uint) error {
 	return db.Delete(&ResponseRule{}, ruleID).Error
 }
 
-func (db *DB) GetConfigForRequest(endpoint, source string) (*Config, error) {
+// GetConfigForRequest 查找请求对应的配置，并返回模板或正则路径捕获的路径变量。
+// 精确路径优先于模板路径，模板路径优先于正则路径，同一路径下指定设备优先于通用配置，再其次指定方法优先于任意方法。
+func (db *DB) GetConfigForRequest(method, path, source string) (*Config, map[string]string, error) {
 	var config Config
-	err := db.Where("endpoint = ? AND source = ?", endpoint, source).Preload("Rules").First(&config).Error
+	err := db.Where("endpoint = ? AND method IN ? AND source IN ?", path, []string{method, ""}, []string{source, ""}).
+		Order("source = '' asc, method = '' asc").
+		Preload("Rules", orderRules).
+		First(&config).Error
 	if err == nil {
-		return &config, nil
+		return &config, nil, nil
 	}
 	if err != gorm.ErrRecordNotFound {
-		return nil, err
+		return nil, nil, err
 	}
 
-	err = db.Where("endpoint = ? AND (source IS NULL OR source = ?)", endpoint, "").Preload("Rules").First(&config).Error
-	if err == nil {
-		return &config, nil
+	var patterns []Config
+	err = db.Where("(endpoint LIKE ? OR endpoint LIKE ? OR endpoint LIKE ?) AND method IN ? AND source IN ?",
+		"~%", "%{%", "%*%", []string{method, ""}, []string{source, ""}).Find(&patterns).Error
+	if err != nil {
+		return nil, nil, err
 	}
-	if err != gorm.ErrRecordNotFound {
-		return nil, err
+	var candidates []configCandidate
+	for _, cfg := range patterns {
+		pattern, err := ParseEndpointPattern(cfg.Endpoint)
+		if err != nil {
+			log.Printf("storage: Skipping config %d with invalid endpoint %q: %v", cfg.ID, cfg.Endpoint, err)
+			continue
+		}
+		if params, ok := pattern.Match(path); ok {
+			candidates = append(candidates, configCandidate{config: cfg, pattern: pattern, params: params})
+		}
 	}
-
-	return nil, nil
+	if len(candidates) == 0 {
+		return nil, nil, nil
+	}
+	sortCandidates(candidates)
+	best := candidates[0]
+	if best.config.Rules, err = db.GetRulesForConfig(best.config.ID); err != nil {
+		return nil, nil, err
+	}
+	return &best.config, best.params, nil
 }
 
-func (db *DB) GetConfigByEndpoint(endpoint string) (*Config, error) {
+// GetConfig 按 (method, endpoint, source) 精确查找配置。
+func (db *DB) GetConfig(method, endpoint, source string) (*Config, error) {
 	var config Config
-	result := db.Where("endpoint = ?", endpoint).Preload("Rules").First(&config)
+	result := db.Where("method = ? AND endpoint = ? AND source = ?", method, endpoint, source).Preload("Rules", orderRules).First(&config)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			return nil, nil
@@ -206,18 +482,40 @@ func (db *DB) RemoveServiceInstance(address string) error {
 	return db.Where("address = ?", address).Delete(&ServiceInstance{}).Error
 }
 
-func (db *DB) SetConfig(endpoint, project, remark, response, source string) error {
-	config := Config{
-		Endpoint:        endpoint,
-		Project:         project,
-		Remark:          remark,
-		DefaultResponse: response,
-		Source:          source,
-	}
+// SetConfig 按 (method, endpoint, source) 创建或更新配置。
+func (db *DB) SetConfig(config *Config) error {
 	return db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "endpoint"}},
-		DoUpdates: clause.AssignmentColumns([]string{"project", "remark", "default_response", "source"}),
-	}).Create(&config).Error
+		Columns: []clause.Column{{Name: "method"}, {Name: "endpoint"}, {Name: "source"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"project", "remark", "default_response", "status_code", "content_type", "headers", "hold_timeout",
+			"updated_at", "deleted_at",
+		}),
+	}).Create(config).Error
+}
+
+// SetConfigWithRules 在同一事务中创建或更新配置并为其追加规则，任一步失败时全部回滚。
+func (db *DB) SetConfigWithRules(config *Config, rules []ResponseRule) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		txDB := &DB{tx}
+		if err := txDB.SetConfig(config); err != nil {
+			return err
+		}
+		// 更新已有配置时 config.ID 不一定是已有记录的 ID，需要重新读取
+		saved, err := txDB.GetConfig(config.Method, config.Endpoint, config.Source)
+		if err != nil {
+			return err
+		}
+		if saved == nil {
+			return gorm.ErrRecordNotFound
+		}
+		for i := range rules {
+			rules[i].ConfigID = saved.ID
+			if err := tx.Create(&rules[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func (db *DB) GetAllConfigSources() ([]string, error) {
@@ -235,59 +533,87 @@ func (db *DB) GetAllConfigSources() ([]string, error) {
 
 func (db *DB) GetAllConfigs() ([]Config, error) {
 	var configs []Config
-	result := db.Order("project, source, endpoint").Find(&configs)
+	result := db.Order("project, source, endpoint, method").Find(&configs)
 	return configs, result.Error
 }
 
-func (db *DB) DeleteConfig(endpoint string) error {
-	return db.Where("endpoint = ?", endpoint).Delete(&Config{}).Error
+// DeleteConfig 删除配置及其规则，避免之后以相同的键重新创建时带回旧规则。
+func (db *DB) DeleteConfig(method, endpoint, source string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var config Config
+		err := tx.Where("method = ? AND endpoint = ? AND source = ?", method, endpoint, source).First(&config).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("config_id = ?", config.ID).Delete(&ResponseRule{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&config).Error
+	})
 }
 
-func (db *DB) CreateEvent(requestID, endpoint, project, payload, responseBody, status, source string) error {
-	event := Event{
-		RequestID:    requestID,
-		Endpoint:     endpoint,
-		Project:      project,
-		Payload:      payload,
-		ResponseBody: responseBody,
-		Status:       status,
-		Timestamp:    time.Now(),
-		Source:       source,
+// CreateEvent 保存一条历史记录，未设置 Timestamp 时取当前时间。
+func (db *DB) CreateEvent(event *Event) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
 	}
-	return db.Create(&event).Error
+	return db.Create(event).Error
 }
 
-func (db *DB) UpdateEventResponse(requestID, responseBody, status string) error {
-	return db.Model(&Event{}).Where("request_id = ?", requestID).Updates(map[string]interface{}{
-		"response_body": responseBody,
-		"status":        status,
-	}).Error
+// UpdateEventResponse 将挂起请求的最终响应（响应体、状态、状态码、响应头与耗时）写回历史记录。
+func (db *DB) UpdateEventResponse(event *Event) error {
+	return db.Model(&Event{}).Where("request_id = ?", event.RequestID).
+		Select("response_body", "status", "status_code", "response_headers", "latency_ms").
+		Updates(event).Error
 }
 
-func (db *DB) GetEvents(page, pageSize int, project, search, source string) ([]Event, int64, error) {
-	var events []Event
-	var total int64
-	query := db.Model(&Event{})
-	if project != "" {
-		query = query.Where("project = ?", project)
-	}
-	if source != "" {
-		query = query.Where("source = ?", source)
-	}
-	if search != "" {
-		searchPattern := "%" + search + "%"
-		query = query.Where("endpoint LIKE ? OR payload LIKE ? OR response_body LIKE ?", searchPattern, searchPattern, searchPattern)
-	}
-	err := query.Count(&total).Error
+// GetEvents 按过滤条件、排序与分页方式查询历史记录。
+func (db *DB) GetEvents(filter EventFilter, page PageRequest) ([]Event, PageResult, error) {
+	events, result, err := findPage(filter.apply(db.Model(&Event{})), eventSortColumns, page, eventSortKey)
 	if err != nil {
-		return nil, 0, err
+		return nil, result, err
+	}
+	if err := db.fillEventSnippets(filter, events); err != nil {
+		return nil, result, err
 	}
-	offset := (page - 1) * pageSize
-	err = query.Order("timestamp desc").Offset(offset).Limit(pageSize).Find(&events).Error
+	return events, result, nil
+}
+
+// exportBatchSize 是导出历史记录时每批从数据库读取的条数。
+const exportBatchSize = 500
+
+// EachEvent 按写入顺序分批读取符合条件的历史记录并逐条交给 fn，fn 返回错误时停止读取并返回该错误。
+func (db *DB) EachEvent(filter EventFilter, fn func(*Event) error) error {
+	var batch []Event
+	return filter.apply(db.Model(&Event{})).FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// GetEvent 按请求 ID 查询历史记录，不存在时返回 nil。
+func (db *DB) GetEvent(requestID string) (*Event, error) {
+	var event Event
+	err := db.Where("request_id = ?", requestID).First(&event).Error
 	if err != nil {
-		return nil, 0, err
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
 	}
-	return events, total, nil
+	return &event, nil
+}
+
+// SetEventReplayDiff 记录重放结果与原始响应的差异。
+func (db *DB) SetEventReplayDiff(requestID, diff string) error {
+	return db.Model(&Event{}).Where("request_id = ?", requestID).Update("replay_diff", diff).Error
 }
 
 func (db *DB) GetEventStatus(requestID string) (string, error) {
@@ -299,6 +625,13 @@ func (db *DB) GetEventStatus(requestID string) (string, error) {
 	return event.Status, nil
 }
 
+// GetEventsByRequestIDs 按请求 ID 查询历史记录，结果按时间先后排列。
+func (db *DB) GetEventsByRequestIDs(requestIDs []string) ([]Event, error) {
+	var events []Event
+	err := db.Where("request_id IN ?", requestIDs).Order("julianday(timestamp) asc, id asc").Find(&events).Error
+	return events, err
+}
+
 func (db *DB) GetAllEventSources() ([]string, error) {
 	var sources []string
 	err := db.Model(&Event{}).
@@ -311,30 +644,24 @@ func (db *DB) GetAllEventSources() ([]string, error) {
 	}
 	return sources, nil
 }
-func (db *DB) SetSshConfig(command, project, remark, response string) error {
-	config := SshConfig{
-		Command:  command,
-		Project:  project,
-		Remark:   remark,
-		Response: response,
-	}
+func (db *DB) SetSshConfig(config *SshConfig) error {
 	return db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "command"}},
-		DoUpdates: clause.AssignmentColumns([]string{"project", "remark", "response"}),
-	}).Create(&config).Error
+		Columns:   []clause.Column{{Name: "command"}, {Name: "mode"}},
+		DoUpdates: clause.AssignmentColumns([]string{"priority", "project", "remark", "response", "stderr", "exit_code", "hold_timeout", "updated_at", "deleted_at"}),
+	}).Create(config).Error
 }
 
 // GetAllSshConfigs retrieves all SSH mock configurations.
 func (db *DB) GetAllSshConfigs() ([]SshConfig, error) {
 	var configs []SshConfig
-	result := db.Order("project, command").Find(&configs)
+	result := db.Order("project, priority desc, command, mode").Find(&configs)
 	return configs, result.Error
 }
 
-// GetSshConfigForCommand retrieves the configuration for a specific command.
-func (db *DB) GetSshConfigForCommand(command string) (*SshConfig, error) {
+// GetSshConfig retrieves the configuration stored under exactly this command or pattern and mode.
+func (db *DB) GetSshConfig(command, mode string) (*SshConfig, error) {
 	var config SshConfig
-	err := db.Where("command = ?", command).First(&config).Error
+	err := db.Where("command = ? AND mode = ?", command, mode).First(&config).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, nil
@@ -345,51 +672,43 @@ func (db *DB) GetSshConfigForCommand(command string) (*SshConfig, error) {
 }
 
 // DeleteSshConfig removes an SSH mock configuration.
-func (db *DB) DeleteSshConfig(command string) error {
-	return db.Where("command = ?", command).Delete(&SshConfig{}).Error
+func (db *DB) DeleteSshConfig(command, mode string) error {
+	return db.Where("command = ? AND mode = ?", command, mode).Delete(&SshConfig{}).Error
 }
 
 // CreateSshEvent records a new SSH command event.
-func (db *DB) CreateSshEvent(requestID, command, project, responseBody, status string) error {
-	event := SshEvent{
-		RequestID:    requestID,
-		Command:      command,
-		Project:      project,
-		ResponseBody: responseBody,
-		Status:       status,
-		Timestamp:    time.Now(),
-	}
-	return db.Create(&event).Error
-}
-
-// UpdateSshEventResponse updates the response and status of an SSH command event.
-func (db *DB) UpdateSshEventResponse(requestID, responseBody, status string) error {
-	return db.Model(&SshEvent{}).Where("request_id = ?", requestID).Updates(map[string]interface{}{
-		"response_body": responseBody,
-		"status":        status,
+func (db *DB) CreateSshEvent(event *SshEvent) error {
+	event.Timestamp = time.Now()
+	return db.Create(event).Error
+}
+
+// UpdateSshEventResponse updates the output, exit code and status of an SSH command event.
+func (db *DB) UpdateSshEventResponse(event *SshEvent) error {
+	return db.Model(&SshEvent{}).Where("request_id = ?", event.RequestID).
+		Select("response_body", "stderr", "exit_code", "status").Updates(event).Error
+}
+
+// EachSshEvent 按写入顺序分批读取符合条件的 SSH 历史记录并逐条交给 fn，fn 返回错误时停止读取并返回该错误。
+func (db *DB) EachSshEvent(filter SshEventFilter, fn func(*SshEvent) error) error {
+	var batch []SshEvent
+	return filter.apply(db.Model(&SshEvent{})).FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
 	}).Error
 }
 
-// GetSshEvents retrieves a paginated list of SSH events.
-func (db *DB) GetSshEvents(page, pageSize int, project, search string) ([]SshEvent, int64, error) {
-	var events []SshEvent
-	var total int64
-	query := db.Model(&SshEvent{})
-	if project != "" {
-		query = query.Where("project = ?", project)
-	}
-	if search != "" {
-		searchPattern := "%" + search + "%"
-		query = query.Where("command LIKE ? OR response_body LIKE ?", searchPattern, searchPattern)
-	}
-	err := query.Count(&total).Error
+// GetSshEvents retrieves a sorted, paginated list of SSH events.
+func (db *DB) GetSshEvents(filter SshEventFilter, page PageRequest) ([]SshEvent, PageResult, error) {
+	events, result, err := findPage(filter.apply(db.Model(&SshEvent{})), sshEventSortColumns, page, sshEventSortKey)
 	if err != nil {
-		return nil, 0, err
+		return nil, result, err
 	}
-	offset := (page - 1) * pageSize
-	err = query.Order("timestamp desc").Offset(offset).Limit(pageSize).Find(&events).Error
-	if err != nil {
-		return nil, 0, err
+	if err := db.fillSshEventSnippets(filter, events); err != nil {
+		return nil, result, err
 	}
-	return events, total, nil
+	return events, result, nil
 }
@@ -0,0 +1,65 @@
+package storage
+
+import "time"
+
+// UnmatchedEndpoint 是未命中任何配置的一类请求（方法、路径、设备相同）的汇总。
+type UnmatchedEndpoint struct {
+	Method     string    `json:"method"`
+	Endpoint   string    `json:"endpoint"`
+	Source     string    `json:"source"`
+	Count      int64     `json:"count"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Configured bool      `json:"configured"` // 现在是否已有配置能匹配这类请求
+	Sample     *Event    `json:"sample"`     // 最近的一条请求
+}
+
+// GetUnmatchedEndpoints 按方法、路径与设备汇总符合条件的未命中配置的请求，按次数从多到少排列。
+func (db *DB) GetUnmatchedEndpoints(filter EventFilter) ([]UnmatchedEndpoint, error) {
+	unmatched := true
+	filter.Unmatched = &unmatched
+	var groups []struct {
+		Method   string
+		Endpoint string
+		Source   string
+		Count    int64
+		SampleID uint
+	}
+	err := filter.apply(db.Model(&Event{})).
+		Select("COALESCE(method, '') AS method, endpoint, source, COUNT(*) AS count, MAX(id) AS sample_id").
+		Group("COALESCE(method, ''), endpoint, source").
+		Order("count DESC, endpoint, method, source").
+		Scan(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(groups))
+	for i, g := range groups {
+		ids[i] = g.SampleID
+	}
+	var samples []Event
+	if len(ids) > 0 {
+		if err := db.Where("id IN ?", ids).Find(&samples).Error; err != nil {
+			return nil, err
+		}
+	}
+	byID := make(map[uint]*Event, len(samples))
+	for i := range samples {
+		byID[samples[i].ID] = &samples[i]
+	}
+
+	result := make([]UnmatchedEndpoint, 0, len(groups))
+	for _, g := range groups {
+		item := UnmatchedEndpoint{Method: g.Method, Endpoint: g.Endpoint, Source: g.Source, Count: g.Count, Sample: byID[g.SampleID]}
+		if item.Sample != nil {
+			item.LastSeen = item.Sample.Timestamp
+		}
+		config, _, err := db.GetConfigForRequest(g.Method, g.Endpoint, g.Source)
+		if err != nil {
+			return nil, err
+		}
+		item.Configured = config != nil
+		result = append(result, item)
+	}
+	return result, nil
+}
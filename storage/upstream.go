@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Upstream 是录制代理模式下的真实服务：未命中任何配置的请求会被转发到 URL，真实响应返回给调用方并记入历史。
+type Upstream struct {
+	gorm.Model
+	Project          string `gorm:"index"` // 录制的历史记录与自动创建的配置归属的工程
+	Source           string `gorm:"index"` // 为空表示任意设备
+	PathPrefix       string // 为空表示任意路径
+	URL              string // 真实服务的基础地址，请求路径与查询参数拼接在其后
+	AutoCreateConfig bool   // 用录制到的响应自动创建配置
+	Remark           string
+}
+
+// matchesPath 判断请求路径是否位于 PathPrefix 之下，按完整路径段比较。
+func (u *Upstream) matchesPath(path string) bool {
+	prefix := strings.TrimSuffix(u.PathPrefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// GetAllUpstreams 返回所有上游服务。
+func (db *DB) GetAllUpstreams() ([]Upstream, error) {
+	var upstreams []Upstream
+	err := db.Order("project, source, path_prefix").Find(&upstreams).Error
+	return upstreams, err
+}
+
+// SaveUpstream 创建上游服务，ID 不为 0 时整体更新已有的上游服务。
+func (db *DB) SaveUpstream(upstream *Upstream) error {
+	if upstream.ID == 0 {
+		return db.Create(upstream).Error
+	}
+	var existing Upstream
+	if err := db.First(&existing, upstream.ID).Error; err != nil {
+		return err
+	}
+	upstream.CreatedAt = existing.CreatedAt
+	return db.Save(upstream).Error
+}
+
+// DeleteUpstream 删除上游服务。
+func (db *DB) DeleteUpstream(id uint) error {
+	return db.Delete(&Upstream{}, id).Error
+}
+
+// GetUpstreamForRequest 返回未命中配置的请求应转发到的上游服务，没有时返回 nil。
+// 指定设备的上游优先于通用上游，同一设备下路径前缀更长者优先。
+func (db *DB) GetUpstreamForRequest(path, source string) (*Upstream, error) {
+	var upstreams []Upstream
+	err := db.Where("source = ? OR source = ?", source, "").Order("id").Find(&upstreams).Error
+	if err != nil {
+		return nil, err
+	}
+	var candidates []Upstream
+	for _, u := range upstreams {
+		if u.matchesPath(path) {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if (a.Source == "") != (b.Source == "") {
+			return a.Source != ""
+		}
+		return len(strings.TrimSuffix(a.PathPrefix, "/")) > len(strings.TrimSuffix(b.PathPrefix, "/"))
+	})
+	return &candidates[0], nil
+}
@@ -15,7 +15,7 @@ export default function RootLayout({
         <html lang="zh-CN">
             <head>
                 {/* THE FIX: This script will be served dynamically by the Go backend */}
-                <script src="/app.config.js"></script>
+                <script src="/_ui/app.config.js"></script>
             </head>
             <body>
                 <main className="container" style={{backgroundColor: 'transparent', boxShadow: 'none', padding: 0}}>
@@ -15,27 +15,57 @@ function getApiBaseUrl(): string {
 
 interface ResponseRule {
     ID: number;
+    Priority: number;
     Keyword: string;
+    Matcher?: any; // 结构化匹配条件，表单不编辑，更新时原样提交
     Response: string;
+    StatusCode: number;
+    ContentType: string;
+    Headers: Record<string, string> | null;
+}
+
+// 响应头在表单中以每行一个 "Name: Value" 的形式编辑
+function formatHeaders(headers: Record<string, string> | null | undefined): string {
+    return Object.entries(headers || {}).map(([k, v]) => `${k}: ${v}`).join('\n');
+}
+
+function parseHeaders(text: string): Record<string, string> {
+    const headers: Record<string, string> = {};
+    text.split('\n').forEach(line => {
+        const idx = line.indexOf(':');
+        if (idx > 0) {
+            headers[line.substring(0, idx).trim()] = line.substring(idx + 1).trim();
+        }
+    });
+    return headers;
 }
 
 const ConfigForm = () => {
     const router = useRouter();
     const searchParams = useSearchParams();
     const endpointToEdit = searchParams.get('endpoint');
+    const methodToEdit = searchParams.get('method') || '';
+    const sourceToEdit = searchParams.get('source') || '';
 
     const [isEditMode, setIsEditMode] = useState(!!endpointToEdit);
     const [currentEndpoint, setCurrentEndpoint] = useState(endpointToEdit);
     const [activeView, setActiveView] = useState<'config' | 'rules'>('config');
 
-    const swrKey = currentEndpoint ? `/api/config${currentEndpoint}` : null;
+    const swrKey = currentEndpoint
+        ? `/api/config/?endpoint=${encodeURIComponent(currentEndpoint)}&method=${encodeURIComponent(methodToEdit)}&source=${encodeURIComponent(sourceToEdit)}`
+        : null;
     const { data: config, error, mutate: mutateConfig } = useSWR(swrKey, fetcher);
 
     const [endpointInput, setEndpointInput] = useState(endpointToEdit || '');
+    const [method, setMethod] = useState(methodToEdit);
     const [project, setProject] = useState('');
     const [remark, setRemark] = useState('');
     const [defaultResponse, setDefaultResponse] = useState('');
     const [source, setSource] = useState('');
+    const [statusCode, setStatusCode] = useState('');
+    const [contentType, setContentType] = useState('');
+    const [headersText, setHeadersText] = useState('');
+    const [holdTimeout, setHoldTimeout] = useState('');
     const [statusMessage, setStatusMessage] = useState({ text: '', type: '' });
 
     const [newKeyword, setNewKeyword] = useState('');
@@ -52,13 +82,18 @@ const ConfigForm = () => {
             setDefaultResponse(config.DefaultResponse || '');
             setSource(config.Source || '');
             setEndpointInput(config.Endpoint || '');
+            setMethod(config.Method || '');
+            setStatusCode(config.StatusCode ? String(config.StatusCode) : '');
+            setContentType(config.ContentType || '');
+            setHeadersText(formatHeaders(config.Headers));
+            setHoldTimeout(config.HoldTimeout === null || config.HoldTimeout === undefined ? '' : String(config.HoldTimeout));
         }
     }, [isEditMode, config]);
 
     const handleSubmit = async (e: React.FormEvent) => {
         e.preventDefault();
-        if (!endpointInput.startsWith('/')) {
-            setStatusMessage({ text: '接口路径必须以 / 开头。', type: 'error' });
+        if (!endpointInput.startsWith('/') && !endpointInput.startsWith('~')) {
+            setStatusMessage({ text: '接口路径必须以 / 开头，正则路径以 ~ 开头。', type: 'error' });
             return;
         }
 
@@ -69,7 +104,11 @@ const ConfigForm = () => {
             const res = await fetch(`${API_BASE_URL}/api/config`, {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ endpoint: endpointInput, project, remark, defaultResponse, source }),
+                body: JSON.stringify({
+                    method, endpoint: endpointInput, project, remark, defaultResponse, source,
+                    statusCode: Number(statusCode) || 0, contentType, headers: parseHeaders(headersText),
+                    holdTimeout: holdTimeout === '' ? null : Number(holdTimeout),
+                }),
             });
 
             if (!res.ok) {
@@ -80,7 +119,7 @@ const ConfigForm = () => {
             setStatusMessage({ text: '配置已成功保存！', type: 'success' });
 
             if (!isEditMode) {
-                router.push(`/configs/edit?endpoint=${encodeURIComponent(endpointInput)}`);
+                router.push(`/configs/edit?endpoint=${encodeURIComponent(endpointInput)}&method=${encodeURIComponent(method)}&source=${encodeURIComponent(source)}`);
             } else {
                 mutateConfig();
             }
@@ -119,11 +158,15 @@ const ConfigForm = () => {
 
     const handleUpdateRule = async (ruleId: number) => {
         const API_BASE_URL = getApiBaseUrl();
+        const existing = config?.Rules?.find((r: ResponseRule) => r.ID === ruleId);
         try {
             const res = await fetch(`${API_BASE_URL}/api/rules/${ruleId}`, {
                 method: 'PUT',
                 headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ keyword: newKeyword, response: newResponse }),
+                body: JSON.stringify({
+                    keyword: newKeyword, matcher: existing?.Matcher, response: newResponse,
+                    statusCode: existing?.StatusCode, contentType: existing?.ContentType, headers: existing?.Headers,
+                }),
             });
             if (!res.ok) throw new Error('更新规则失败');
             const updatedRule = await res.json();
@@ -180,22 +223,48 @@ const ConfigForm = () => {
                     </div>
                     <div className="form-group">
                         <label htmlFor="source">设备 (Source)</label>
-                        <input type="text" id="source" value={source} onChange={e => setSource(e.target.value)} placeholder="例如：127.0.0.1:8080" />
+                        <input type="text" id="source" value={source} onChange={e => setSource(e.target.value)} readOnly={isEditMode} placeholder="例如：127.0.0.1:8080" />
                         <small>留空表示此配置适用于所有设备。</small>
                     </div>
                     <div className="form-group">
                         <label htmlFor="remark">备注 (Remark)</label>
                         <input type="text" id="remark" value={remark} onChange={e => setRemark(e.target.value)} placeholder="例如：获取用户信息接口" />
                     </div>
+                    <div className="form-group">
+                        <label htmlFor="method">请求方法 (Method)</label>
+                        <select id="method" value={method} onChange={e => setMethod(e.target.value)} disabled={isEditMode}>
+                            <option value="">任意方法</option>
+                            {['GET', 'POST', 'PUT', 'PATCH', 'DELETE', 'HEAD', 'OPTIONS'].map(m => <option key={m} value={m}>{m}</option>)}
+                        </select>
+                    </div>
                     <div className="form-group">
                         <label htmlFor="endpoint">接口路径 (Endpoint)</label>
-                        <input type="text" id="endpoint" value={endpointInput} onChange={e => setEndpointInput(e.target.value)} readOnly={isEditMode} placeholder="/my/custom/api" required />
+                        <input type="text" id="endpoint" value={endpointInput} onChange={e => setEndpointInput(e.target.value)} readOnly={isEditMode} placeholder="/my/custom/api、/orders/{id}、/files/**、~^/re/(?P<id>\d+)$" required />
                     </div>
                     <div className="form-group">
                         <label htmlFor="defaultResponse">默认响应内容</label>
                         <textarea id="defaultResponse" value={defaultResponse} onChange={e => setDefaultResponse(e.target.value)} placeholder='{"code": 200, "message": "OK" ...}' required />
                     </div>
 
+                    <div className="form-group">
+                        <label htmlFor="statusCode">响应状态码</label>
+                        <input type="number" id="statusCode" value={statusCode} onChange={e => setStatusCode(e.target.value)} placeholder="200" />
+                    </div>
+                    <div className="form-group">
+                        <label htmlFor="contentType">响应内容类型 (Content-Type)</label>
+                        <input type="text" id="contentType" value={contentType} onChange={e => setContentType(e.target.value)} placeholder="application/xml; charset=utf-8" />
+                    </div>
+                    <div className="form-group">
+                        <label htmlFor="headers">响应头</label>
+                        <textarea id="headers" value={headersText} onChange={e => setHeadersText(e.target.value)} placeholder={'每行一个，例如：\nX-Trace-Id: abc'} />
+                    </div>
+
+                    <div className="form-group">
+                        <label htmlFor="holdTimeout">挂起时长（秒）</label>
+                        <input type="number" id="holdTimeout" value={holdTimeout} onChange={e => setHoldTimeout(e.target.value)} placeholder="留空沿用工程或全局设置" />
+                        <small>有实时监控页面连接时，等待人工响应的时长；-1 表示一直等待，直到人工响应。</small>
+                    </div>
+
                     <div className="form-buttons">
                         {/* THE FIX: Added 'btn' and 'btn-primary' classes */}
                         <button type="submit" className="btn btn-primary">{isEditMode ? '更新配置' : '保存并配置规则'}</button>
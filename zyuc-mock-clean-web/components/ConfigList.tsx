@@ -7,6 +7,7 @@ import { fetcher, escapeHtml } from '../lib/utils';
 
 interface Config {
     ID: number;
+    Method: string; // 为空表示任意方法
     Endpoint: string;
     Project: string;
     Remark: string;
@@ -25,10 +26,14 @@ const ConfigList = () => {
 
     const API_BASE_URL = process.env.NEXT_PUBLIC_API_BASE_URL || 'http://localhost:8080';
 
-    const handleDelete = async (endpoint: string) => {
-        if (confirm(`确定要删除接口 "${endpoint}" 的配置吗？此操作不可恢复。`)) {
+    const configKeyQuery = (config: Config) =>
+        `method=${encodeURIComponent(config.Method || '')}&source=${encodeURIComponent(config.Source || '')}`;
+
+    const handleDelete = async (config: Config) => {
+        const endpoint = config.Endpoint;
+        if (confirm(`确定要删除接口 "${config.Method || 'ANY'} ${endpoint}" 的配置吗？此操作不可恢复。`)) {
             try {
-                await fetch(`${API_BASE_URL}/api/config${endpoint}`, { method: 'DELETE' });
+                await fetch(`${API_BASE_URL}/api/config/?endpoint=${encodeURIComponent(endpoint)}&${configKeyQuery(config)}`, { method: 'DELETE' });
                 mutate('/api/configs');
                 mutate('/api/configs/sources'); // 删除后同时刷新设备列表
                 alert('配置已成功删除！');
@@ -111,7 +116,7 @@ const ConfigList = () => {
                                 {configs.map(config => (
                                     <tr key={config.ID}>
                                         <td className="endpoint-cell">
-                                            <div>{escapeHtml(config.Endpoint)}</div>
+                                            <div>{config.Method || 'ANY'} {escapeHtml(config.Endpoint)}</div>
                                             <div className="remark">{escapeHtml(config.Remark || '无备注')}</div>
                                         </td>
                                         <td>
@@ -119,8 +124,8 @@ const ConfigList = () => {
                                         </td>
                                         <td>
                                             <div className="actions">
-                                                <Link href={`/configs/edit?endpoint=${encodeURIComponent(config.Endpoint)}`} className="btn btn-sm btn-success">编辑</Link>
-                                                <button onClick={() => handleDelete(config.Endpoint)} className="btn btn-sm btn-danger">删除</button>
+                                                <Link href={`/configs/edit?endpoint=${encodeURIComponent(config.Endpoint)}&${configKeyQuery(config)}`} className="btn btn-sm btn-success">编辑</Link>
+                                                <button onClick={() => handleDelete(config)} className="btn btn-sm btn-danger">删除</button>
                                             </div>
                                         </td>
                                     </tr>
@@ -11,11 +11,28 @@ function getApiBaseUrl(): string {
     return 'http://localhost:8080';
 }
 
-const EventItem = ({ eventData, primaryServiceUrl }: { eventData: SseEventData, primaryServiceUrl: string | null }) => {
-    const { requestId, defaultResponse, type } = eventData;
+// getOperatorName 返回当前浏览器的操作员名称，首次使用时随机生成并保存在 localStorage 中。
+function getOperatorName(): string {
+    if (typeof window === 'undefined') return '';
+    let name = window.localStorage.getItem('operatorName');
+    if (!name) {
+        name = `操作员-${Math.random().toString(36).slice(2, 6)}`;
+        window.localStorage.setItem('operatorName', name);
+    }
+    return name;
+}
+
+const EventItem = ({ eventData, primaryServiceUrl, operator }: { eventData: SseEventData, primaryServiceUrl: string | null, operator: string }) => {
+    const { requestId, defaultResponse, type, expiresAt, resolvedStatus, claimedBy } = eventData;
+    const holdsIndefinitely = eventData.holdTimeout === -1;
+    const claimedByOther = !!claimedBy && claimedBy !== operator;
+    const operatorHeaders = { 'Content-Type': 'application/json', 'X-Operator': operator };
 
     const [responseBody, setResponseBody] = useState(defaultResponse);
-    const [status, setStatus] = useState('将在 0 秒后自动返回默认内容...');
+    // SSH 命令的标准错误输出与退出码
+    const [stderr, setStderr] = useState(eventData.defaultStderr || '');
+    const [exitCode, setExitCode] = useState(String(eventData.defaultExitCode ?? 0));
+    const [status, setStatus] = useState('');
     const [isProcessing, setIsProcessing] = useState(false);
     const [isCompleted, setIsCompleted] = useState(false);
     const [timerCleared, setTimerCleared] = useState(false);
@@ -25,13 +42,19 @@ const EventItem = ({ eventData, primaryServiceUrl }: { eventData: SseEventData,
             return;
         }
 
-        let secondsLeft = 0;
-        setStatus(`将在 0 秒后自动返回默认内容...`);
+        if (holdsIndefinitely) {
+            setStatus('⏸ 请求已挂起，等待人工响应...');
+            return;
+        }
+
+        const deadline = expiresAt || Date.now();
+        const tick = () => Math.max(0, Math.ceil((deadline - Date.now()) / 1000));
+        setStatus(`将在 ${tick()} 秒后自动返回默认内容...`);
 
         const timerId = setInterval(() => {
+            const secondsLeft = tick();
             if (secondsLeft > 0) {
                 setStatus(`将在 ${secondsLeft} 秒后自动返回默认内容...`);
-                secondsLeft--;
             } else {
                 setStatus('✔ 默认响应已成功发送。');
                 setIsCompleted(true);
@@ -39,12 +62,57 @@ const EventItem = ({ eventData, primaryServiceUrl }: { eventData: SseEventData,
             }
         }, 1000);
         return () => clearInterval(timerId);
-    }, [isCompleted, timerCleared]);
+    }, [isCompleted, timerCleared, holdsIndefinitely, expiresAt]);
+
+    // 请求已被其他操作员或接口处理
+    useEffect(() => {
+        if (resolvedStatus && !isCompleted && !isProcessing) {
+            setStatus(`✔ 请求已处理: ${resolvedStatus}`);
+            setIsCompleted(true);
+        }
+    }, [resolvedStatus, isCompleted, isProcessing]);
+
+    const claim = async () => {
+        if (!primaryServiceUrl || claimedBy === operator) return true;
+        try {
+            const res = await fetch(`${primaryServiceUrl}/api/pending/${requestId}/claim`, {
+                method: 'POST',
+                headers: operatorHeaders,
+            });
+            if (!res.ok) {
+                const err = await res.json();
+                throw new Error(err.error || '无法认领该请求。');
+            }
+            return true;
+        } catch (error: any) {
+            setStatus(`❌ 认领失败: ${error.message}`);
+            return false;
+        }
+    };
+
+    const extendHold = async () => {
+        if (!primaryServiceUrl) return;
+        try {
+            const res = await fetch(`${primaryServiceUrl}/api/pending/${requestId}/extend`, {
+                method: 'POST',
+                headers: operatorHeaders,
+                body: JSON.stringify({ seconds: 30 }),
+            });
+            if (!res.ok) {
+                const err = await res.json();
+                throw new Error(err.error || '无法延长挂起时间。');
+            }
+        } catch (error: any) {
+            setStatus(`❌ 延长失败: ${error.message}`);
+        }
+    };
 
     const handleInteraction = () => {
         if (!timerCleared) {
             setTimerCleared(true);
             setStatus('已手动修改，请点击按钮提交。');
+            // 开始编辑即认领该请求，避免与其他操作员冲突
+            claim();
         }
     };
 
@@ -54,6 +122,10 @@ const EventItem = ({ eventData, primaryServiceUrl }: { eventData: SseEventData,
     };
 
     const sendResponse = async (content: string, responseStatus: 'Custom' | 'Default') => {
+        // SSH 命令的回复不沿用默认的错误输出与退出码，返回默认值时一并带上
+        const sshOutput = type !== 'ssh' ? {} : responseStatus === 'Custom'
+            ? { stderr, exitCode: Number(exitCode) || 0 }
+            : { stderr: eventData.defaultStderr || '', exitCode: eventData.defaultExitCode ?? 0 };
         if (isProcessing || isCompleted || !primaryServiceUrl) {
             setStatus(`❌ 发送失败: 主节点未连接。`);
             return;
@@ -67,8 +139,8 @@ const EventItem = ({ eventData, primaryServiceUrl }: { eventData: SseEventData,
         try {
             const res = await fetch(targetUrl, {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ requestId, responseBody: content, source: eventData.source }),
+                headers: operatorHeaders,
+                body: JSON.stringify({ requestId, responseBody: content, source: eventData.source, ...sshOutput }),
             });
             if (!res.ok) {
                 const err = await res.json();
@@ -107,7 +179,7 @@ const EventItem = ({ eventData, primaryServiceUrl }: { eventData: SseEventData,
             <>
                 <div className="event-header">
                     <span className="endpoint">
-                        请求接口: {eventData.source} {eventData.endpoint} ({eventData.project || '未分类'})
+                        请求接口: {eventData.source} {eventData.method} {eventData.endpoint} ({eventData.project || '未分类'})
                     </span>
                     <span>接收于: {format(new Date(), 'HH:mm:ss')}</span>
                 </div>
@@ -123,14 +195,39 @@ const EventItem = ({ eventData, primaryServiceUrl }: { eventData: SseEventData,
                 <textarea
                     value={responseBody}
                     onChange={handleResponseChange}
-                    readOnly={isProcessing || isCompleted}
-                    style={{ backgroundColor: (isProcessing || isCompleted) ? '#f1f3f5' : 'white' }}
+                    readOnly={isProcessing || isCompleted || claimedByOther}
+                    style={{ backgroundColor: (isProcessing || isCompleted || claimedByOther) ? '#f1f3f5' : 'white' }}
                 />
+                {type === 'ssh' && (
+                    <div className="ssh-output-editor">
+                        <textarea
+                            value={stderr}
+                            placeholder="标准错误输出 (stderr)"
+                            onChange={e => { handleInteraction(); setStderr(e.target.value); }}
+                            readOnly={isProcessing || isCompleted || claimedByOther}
+                        />
+                        <label>
+                            退出码
+                            <input
+                                type="number" min={0} max={255}
+                                value={exitCode}
+                                onChange={e => { handleInteraction(); setExitCode(e.target.value); }}
+                                readOnly={isProcessing || isCompleted || claimedByOther}
+                            />
+                        </label>
+                    </div>
+                )}
                 <div className="controls">
-                    <p className="status">{status}</p>
+                    <p className="status">{claimedByOther && !isCompleted ? `🔒 已被 ${claimedBy} 认领` : status}</p>
                     <div className="buttons">
-                        <button onClick={() => sendResponse(responseBody, 'Custom')} disabled={isProcessing || isCompleted} className={isCompleted ? "" : "custom-btn"}>返回自定义内容</button>
-                        <button onClick={() => sendResponse(defaultResponse, 'Default')} disabled={isProcessing || isCompleted} className={isCompleted ? "" : "default-btn"}>返回默认值</button>
+                        {!claimedBy && (
+                            <button onClick={claim} disabled={isProcessing || isCompleted}>认领</button>
+                        )}
+                        <button onClick={() => sendResponse(responseBody, 'Custom')} disabled={isProcessing || isCompleted || claimedByOther} className={isCompleted ? "" : "custom-btn"}>返回自定义内容</button>
+                        <button onClick={() => sendResponse(defaultResponse, 'Default')} disabled={isProcessing || isCompleted || claimedByOther} className={isCompleted ? "" : "default-btn"}>返回默认值</button>
+                        {!holdsIndefinitely && (
+                            <button onClick={extendHold} disabled={isProcessing || isCompleted || claimedByOther}>延长 30 秒</button>
+                        )}
                     </div>
                 </div>
             </div>
@@ -140,10 +237,12 @@ const EventItem = ({ eventData, primaryServiceUrl }: { eventData: SseEventData,
 
 const EventStream = () => {
     const [bootstrapUrl, setBootstrapUrl] = useState('');
+    const [operator, setOperator] = useState('');
 
     useEffect(() => {
         const apiUrl = getApiBaseUrl();
         setBootstrapUrl(apiUrl);
+        setOperator(getOperatorName());
     }, []);
 
     const { events, connectionStatus, allServices, primaryService } = useEventSource(bootstrapUrl);
@@ -219,6 +318,7 @@ const EventStream = () => {
                         key={event.requestId}
                         eventData={event}
                         primaryServiceUrl={primaryServiceUrl}
+                        operator={operator}
                     />
                 ))}
             </ul>
@@ -1,11 +1,19 @@
 'use client';
 
-import useSWR from 'swr';
+import useSWR, { mutate } from 'swr';
 import { useState, useMemo } from 'react';
 import { fetcher, debounce } from '../lib/utils';
 import { format } from 'date-fns';
 
+function getApiBaseUrl(): string {
+    if (typeof window !== 'undefined' && (window as any).APP_CONFIG) {
+        return (window as any).APP_CONFIG.apiBaseUrl;
+    }
+    return 'http://localhost:8080';
+}
+
 interface EventHistory {
+    RequestID: string;
     Endpoint: string;
     Project: string;
     Payload: string;
@@ -13,6 +21,32 @@ interface EventHistory {
     Status: string;
     Timestamp: string;
     Source: string;
+    Method?: string;
+    ClientIP?: string;
+    StatusCode?: number;
+    LatencyMs?: number;
+    Unmatched?: boolean;
+    ReplayOf?: string;
+    ReplayDiff?: string;
+    Snippet?: string;
+}
+
+// 搜索摘要中的命中词以 <mark> 标记，按文本拆分渲染，避免把记录内容当作 HTML
+const renderSnippet = (snippet: string) =>
+    snippet.split(/<mark>|<\/mark>/).map((part, i) => i % 2 === 1 ? <mark key={i}>{part}</mark> : part);
+
+interface PromoteChange {
+    op: 'change' | 'add';
+    field: string;
+    old?: any;
+    new: any;
+}
+
+interface PromotePlan {
+    config: { Method: string; Endpoint: string; Source: string; Project: string; DefaultResponse: string };
+    existing: boolean;
+    diff: PromoteChange[];
+    warnings: string[];
 }
 
 interface HistoryResponse {
@@ -28,6 +62,92 @@ const HistoryList = () => {
     const [sourceFilter, setSourceFilter] = useState('');
     const [searchTerm, setSearchTerm] = useState('');
     const [debouncedSearchTerm, setDebouncedSearchTerm] = useState('');
+    const [selected, setSelected] = useState<string[]>([]);
+    const [promoteMethod, setPromoteMethod] = useState('');
+    const [promoteEndpoint, setPromoteEndpoint] = useState('');
+    const [plan, setPlan] = useState<PromotePlan | null>(null);
+    const [promoteError, setPromoteError] = useState('');
+
+    const toggleSelected = (requestId: string) => {
+        setPlan(null);
+        setSelected(prev => prev.includes(requestId) ? prev.filter(id => id !== requestId) : [...prev, requestId]);
+    };
+
+    // 将选中的历史记录转换为配置：commit 为 false 时只预览差异
+    const promote = async (commit: boolean) => {
+        setPromoteError('');
+        try {
+            const res = await fetch(`${getApiBaseUrl()}/api/history/promote`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ requestIds: selected, method: promoteMethod, endpoint: promoteEndpoint, commit }),
+            });
+            const data = await res.json();
+            if (!res.ok) {
+                throw new Error(data.error || '生成配置失败。');
+            }
+            if (commit) {
+                alert('配置已成功创建！');
+                setPlan(null);
+                setSelected([]);
+                mutate('/api/configs');
+            } else {
+                setPlan(data);
+            }
+        } catch (error: any) {
+            setPromoteError(error.message);
+        }
+    };
+
+    // 重新发送历史请求，结果作为新的历史记录出现在列表中
+    const replay = async (requestId: string) => {
+        try {
+            const res = await fetch(`${getApiBaseUrl()}/api/history/${requestId}/replay`, { method: 'POST' });
+            const data = await res.json();
+            if (!res.ok) {
+                throw new Error(data.error || '重放失败。');
+            }
+            alert(data.identical ? '重放完成，响应与原记录一致。' : '重放完成，响应与原记录不同。');
+            mutate(key => typeof key === 'string' && key.startsWith('/api/history?'));
+        } catch (error: any) {
+            alert(`重放失败: ${error.message}`);
+        }
+    };
+
+    // 以未命中配置的请求为样本创建配置，路径可改为模板路径以覆盖同类请求
+    const createConfig = async (event: EventHistory) => {
+        const endpoint = prompt('配置的接口路径（可使用模板路径，如 /orders/{id}）', event.Endpoint);
+        if (endpoint === null) {
+            return;
+        }
+        try {
+            const res = await fetch(`${getApiBaseUrl()}/api/unmatched/${event.RequestID}/config`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ endpoint }),
+            });
+            const data = await res.json();
+            if (!res.ok) {
+                throw new Error(data.error || '创建配置失败。');
+            }
+            alert('配置已成功创建！');
+            mutate('/api/configs');
+        } catch (error: any) {
+            alert(`创建配置失败: ${error.message}`);
+        }
+    };
+
+    const formatChange = (change: PromoteChange) => {
+        if (change.field === 'rule') {
+            return `新增规则: ${JSON.stringify(change.new.matcher)} → ${change.new.response}`;
+        }
+        if (change.field === 'config') {
+            return `新建配置: ${change.new}`;
+        }
+        return change.old !== undefined
+            ? `${change.field}: ${change.old} → ${change.new}`
+            : `${change.field}: ${change.new}`;
+    };
 
     const debounceSearch = useMemo(() => debounce((value: string) => {
         setPage(1);
@@ -69,6 +189,8 @@ const HistoryList = () => {
 
     const { data, total, pageSize } = historyData;
     const totalPages = Math.ceil(total / pageSize);
+    const exportUrl = (format: string) =>
+        `${getApiBaseUrl()}/api/history/export?format=${format}&project=${encodeURIComponent(projectFilter)}&source=${encodeURIComponent(sourceFilter)}&search=${encodeURIComponent(debouncedSearchTerm)}`;
 
     return (
         <div className="list-section">
@@ -83,15 +205,49 @@ const HistoryList = () => {
                 </select>
                 <input
                     type="text"
-                    placeholder="按路径或内容模糊搜索..."
+                    placeholder="按路径或内容搜索，词尾加 * 按前缀匹配..."
                     value={searchTerm}
                     onChange={handleSearchChange}
                 />
+                <span className="export-links">
+                    导出:
+                    {['jsonl', 'csv', 'har'].map(f => <a key={f} href={exportUrl(f)} download>{f.toUpperCase()}</a>)}
+                </span>
             </div>
+            {selected.length > 0 && (
+                <div className="promote-section">
+                    <span>已选择 {selected.length} 条记录</span>
+                    <select value={promoteMethod} onChange={e => { setPlan(null); setPromoteMethod(e.target.value); }}>
+                        <option value="">任意方法</option>
+                        {['GET', 'POST', 'PUT', 'PATCH', 'DELETE'].map(m => <option key={m} value={m}>{m}</option>)}
+                    </select>
+                    <input
+                        type="text"
+                        placeholder="接口路径（留空使用记录中的路径）"
+                        value={promoteEndpoint}
+                        onChange={e => { setPlan(null); setPromoteEndpoint(e.target.value); }}
+                    />
+                    <button onClick={() => promote(false)}>预览生成的配置</button>
+                    <button onClick={() => { setSelected([]); setPlan(null); }}>取消选择</button>
+                    {promoteError && <p className="status">❌ {promoteError}</p>}
+                    {plan && (
+                        <div className="promote-preview">
+                            <p>{plan.existing ? '将更新已有配置' : '将创建新配置'}: {plan.config.Method || 'ANY'} {plan.config.Endpoint}</p>
+                            <ul>
+                                {plan.diff.map((change, i) => <li key={i}><pre>{formatChange(change)}</pre></li>)}
+                                {plan.diff.length === 0 && <li>没有需要修改的内容。</li>}
+                            </ul>
+                            {plan.warnings.map((w, i) => <p key={i} className="status">⚠ {w}</p>)}
+                            <button onClick={() => promote(true)} disabled={plan.diff.length === 0}>确认生成</button>
+                        </div>
+                    )}
+                </div>
+            )}
             <table id="history-table">
                 <thead>
                 <tr>
-                    <th style={{width: '20%'}}>接口 / 工程</th>
+                    <th style={{width: '3%'}}></th>
+                    <th style={{width: '17%'}}>接口 / 工程</th>
                     <th style={{width: '15%'}}>来源 (IP:Port)</th>
                     <th style={{width: '25%'}}>请求内容 (Payload)</th>
                     <th style={{width: '25%'}}>响应内容 (Response)</th>
@@ -102,21 +258,46 @@ const HistoryList = () => {
                 {data && data.length > 0 ? (
                     data.map((event, index) => (
                         <tr key={index}>
+                            <td>
+                                <input type="checkbox" checked={selected.includes(event.RequestID)} onChange={() => toggleSelected(event.RequestID)} />
+                            </td>
                             <td className="endpoint-cell">
-                                <div>{event.Endpoint}</div>
+                                <div>{event.Method && <strong>{event.Method} </strong>}{event.Endpoint}</div>
                                 <div className="project">{event.Project || '未分类'}</div>
+                                {event.Unmatched && (
+                                    <div className="unmatched-info">
+                                        <span className="unmatched-badge">未匹配配置</span>
+                                        <button className="replay-button" onClick={() => createConfig(event)}>创建配置</button>
+                                    </div>
+                                )}
+                            </td>
+                            <td>
+                                <div>{event.Source || 'N/A'}</div>
+                                {event.ClientIP && <div className="project">客户端 {event.ClientIP}</div>}
+                            </td>
+                            <td>
+                                {event.Snippet && <div className="search-snippet">{renderSnippet(event.Snippet)}</div>}
+                                <pre>{event.Payload}</pre>
+                            </td>
+                            <td>
+                                <pre>{event.ResponseBody}</pre>
+                                {event.ReplayOf && (
+                                    <div className="replay-info">
+                                        <span>重放自 {event.ReplayOf}</span>
+                                        {event.ReplayDiff ? <pre>{event.ReplayDiff}</pre> : <span>，响应一致</span>}
+                                    </div>
+                                )}
                             </td>
-                            <td>{event.Source || 'N/A'}</td>
-                            <td><pre>{event.Payload}</pre></td>
-                            <td><pre>{event.ResponseBody}</pre></td>
                             <td className="status-cell">
                                 <span className={`status status-${event.Status.replace(/[\s()]/g, '-')}`}>{event.Status}</span>
+                                {!!event.StatusCode && <span className="timestamp">HTTP {event.StatusCode} · {event.LatencyMs} ms</span>}
                                 <span className="timestamp">{format(new Date(event.Timestamp), 'yyyy-MM-dd HH:mm:ss')}</span>
+                                <button className="replay-button" onClick={() => replay(event.RequestID)}>重放</button>
                             </td>
                         </tr>
                     ))
                 ) : (
-                    <tr><td colSpan={5} style={{ textAlign: 'center' }}>未找到历史记录。</td></tr>
+                    <tr><td colSpan={6} style={{ textAlign: 'center' }}>未找到历史记录。</td></tr>
                 )}
                 </tbody>
             </table>
@@ -16,16 +16,22 @@ const SshConfigForm = () => {
     const router = useRouter();
     const searchParams = useSearchParams();
     const commandToEdit = searchParams.get('command');
+    const modeToEdit = searchParams.get('mode') || '';
 
     const [isEditMode, setIsEditMode] = useState(!!commandToEdit);
 
-    const swrKey = commandToEdit ? `/api/ssh/config/${encodeURIComponent(commandToEdit)}` : null;
+    const swrKey = commandToEdit ? `/api/ssh/config/${encodeURIComponent(commandToEdit)}?mode=${encodeURIComponent(modeToEdit)}` : null;
     const { data: config, error } = useSWR(swrKey, fetcher);
 
     const [commandInput, setCommandInput] = useState(commandToEdit || '');
+    const [mode, setMode] = useState(modeToEdit);
+    const [priority, setPriority] = useState('0');
     const [project, setProject] = useState('');
     const [remark, setRemark] = useState('');
     const [response, setResponse] = useState('');
+    const [stderr, setStderr] = useState('');
+    const [exitCode, setExitCode] = useState('0');
+    const [holdTimeout, setHoldTimeout] = useState('');
     const [statusMessage, setStatusMessage] = useState({ text: '', type: '' });
 
     useEffect(() => {
@@ -33,7 +39,12 @@ const SshConfigForm = () => {
             setProject(config.Project || '');
             setRemark(config.Remark || '');
             setResponse(config.Response || '');
+            setStderr(config.Stderr || '');
+            setExitCode(String(config.ExitCode ?? 0));
             setCommandInput(config.Command || '');
+            setMode(config.Mode || '');
+            setPriority(String(config.Priority ?? 0));
+            setHoldTimeout(config.HoldTimeout === null || config.HoldTimeout === undefined ? '' : String(config.HoldTimeout));
         }
     }, [isEditMode, config]);
 
@@ -46,7 +57,11 @@ const SshConfigForm = () => {
             const res = await fetch(`${API_BASE_URL}/api/ssh/config`, {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ command: commandInput, project, remark, response }),
+                body: JSON.stringify({
+                    command: commandInput, mode, priority: Number(priority) || 0, project, remark, response, stderr,
+                    exitCode: Number(exitCode) || 0,
+                    holdTimeout: holdTimeout === '' ? null : Number(holdTimeout),
+                }),
             });
 
             if (!res.ok) {
@@ -83,10 +98,36 @@ const SshConfigForm = () => {
                 <div className="form-group">
                     <label htmlFor="command">命令 (Command)</label>
                     <input type="text" id="command" value={commandInput} onChange={e => setCommandInput(e.target.value)} readOnly={isEditMode} placeholder="ls -l /var/log" required />
+                    <small>支持 <code>ping {'{host}'}</code>、<code>show interface {'{name...}'}</code> 捕获参数，<code>show *</code> 通配，以 <code>~</code> 开头的正则；输出中可用 <code>{'{{.Args.host}}'}</code> 引用参数。</small>
                 </div>
                 <div className="form-group">
-                    <label htmlFor="response">响应内容</label>
-                    <textarea id="response" value={response} onChange={e => setResponse(e.target.value)} placeholder='total 0\n-rw-r--r-- 1 root root 0 Aug  1 10:00 messages' required />
+                    <label htmlFor="mode">模式 (Mode)</label>
+                    <input type="text" id="mode" value={mode} onChange={e => setMode(e.target.value)} readOnly={isEditMode} placeholder="留空表示任意模式，例如：privileged" />
+                    <small>使用设备配置时，只在该命令行模式下匹配；同一命令可以在不同模式下返回不同输出。</small>
+                </div>
+                <div className="form-group">
+                    <label htmlFor="priority">优先级</label>
+                    <input type="number" id="priority" value={priority} onChange={e => setPriority(e.target.value)} />
+                    <small>多个参数、通配或正则命令同时匹配时，数值大者优先；精确命令总是优先。</small>
+                </div>
+                <div className="form-group">
+                    <label htmlFor="response">响应内容 (stdout)</label>
+                    <textarea id="response" value={response} onChange={e => setResponse(e.target.value)} placeholder='total 0\n-rw-r--r-- 1 root root 0 Aug  1 10:00 messages' />
+                </div>
+                <div className="form-group">
+                    <label htmlFor="stderr">错误输出 (stderr)</label>
+                    <textarea id="stderr" value={stderr} onChange={e => setStderr(e.target.value)} placeholder="ls: cannot access '/nope': No such file or directory" />
+                </div>
+                <div className="form-group">
+                    <label htmlFor="exitCode">退出码</label>
+                    <input type="number" id="exitCode" min={0} max={255} value={exitCode} onChange={e => setExitCode(e.target.value)} />
+                    <small>以 <code>ssh host 'cmd'</code> 等方式执行单条命令时返回给客户端的退出码（0-255）。</small>
+                </div>
+
+                <div className="form-group">
+                    <label htmlFor="holdTimeout">挂起时长（秒）</label>
+                    <input type="number" id="holdTimeout" value={holdTimeout} onChange={e => setHoldTimeout(e.target.value)} placeholder="留空沿用工程或全局设置" />
+                    <small>有实时监控页面连接时，等待人工响应的时长；-1 表示一直等待，直到人工响应。</small>
                 </div>
 
                 <div className="form-buttons">
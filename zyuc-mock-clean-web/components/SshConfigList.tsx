@@ -8,6 +8,7 @@ import { fetcher, escapeHtml } from '../lib/utils';
 interface SshConfig {
     ID: number;
     Command: string;
+    Mode?: string;
     Project: string;
     Remark: string;
     Response: string;
@@ -25,11 +26,11 @@ const SshConfigList = () => {
         return 'http://localhost:8080';
     }
 
-    const handleDelete = async (command: string) => {
+    const handleDelete = async (command: string, mode: string) => {
         if (confirm(`确定要删除命令 "${command}" 的配置吗？此操作不可恢复。`)) {
             try {
                 const API_BASE_URL = getApiBaseUrl();
-                await fetch(`${API_BASE_URL}/api/ssh/config/${encodeURIComponent(command)}`, { method: 'DELETE' });
+                await fetch(`${API_BASE_URL}/api/ssh/config/${encodeURIComponent(command)}?mode=${encodeURIComponent(mode)}`, { method: 'DELETE' });
                 mutate('/api/ssh/configs');
                 alert('配置已成功删除！');
             } catch (err) {
@@ -105,6 +106,7 @@ const SshConfigList = () => {
                                 <tr key={config.ID}>
                                     <td className="endpoint-cell">
                                         <div>{escapeHtml(config.Command)}</div>
+                                        {config.Mode && <div className="remark">模式：{escapeHtml(config.Mode)}</div>}
                                         <div className="remark">{escapeHtml(config.Remark || '无备注')}</div>
                                     </td>
                                     <td>
@@ -112,8 +114,8 @@ const SshConfigList = () => {
                                     </td>
                                     <td>
                                         <div className="actions">
-                                            <Link href={`/ssh-configs/edit?command=${encodeURIComponent(config.Command)}`} className="btn btn-sm btn-success">编辑</Link>
-                                            <button onClick={() => handleDelete(config.Command)} className="btn btn-sm btn-danger">删除</button>
+                                            <Link href={`/ssh-configs/edit?command=${encodeURIComponent(config.Command)}&mode=${encodeURIComponent(config.Mode || '')}`} className="btn btn-sm btn-success">编辑</Link>
+                                            <button onClick={() => handleDelete(config.Command, config.Mode || '')} className="btn btn-sm btn-danger">删除</button>
                                         </div>
                                     </td>
                                 </tr>
@@ -8,7 +8,11 @@ import { format } from 'date-fns';
 interface SshEventHistory {
     Command: string;
     Project: string;
+    Username?: string;
+    Mode?: string;
     ResponseBody: string;
+    Stderr?: string;
+    ExitCode?: number | null;
     Status: string;
     Timestamp: string;
 }
@@ -91,11 +95,15 @@ const SshHistoryList = () => {
                         <tr key={index}>
                             <td className="endpoint-cell">
                                 <div>{event.Command}</div>
-                                <div className="project">{event.Project || '未分类'}</div>
+                                <div className="project">{event.Project || '未分类'}{event.Username && ` · ${event.Username}`}{event.Mode && ` · ${event.Mode}`}</div>
+                            </td>
+                            <td>
+                                <pre>{event.ResponseBody}</pre>
+                                {event.Stderr && <pre className="ssh-stderr">{event.Stderr}</pre>}
                             </td>
-                            <td><pre>{event.ResponseBody}</pre></td>
                             <td className="status-cell">
                                 <span className={`status status-${event.Status.replace(/[\s()]/g, '-')}`}>{event.Status}</span>
+                                {event.ExitCode !== null && event.ExitCode !== undefined && <span className="timestamp">退出码 {event.ExitCode}</span>}
                                 <span className="timestamp">{format(new Date(event.Timestamp), 'yyyy-MM-dd HH:mm:ss')}</span>
                             </td>
                         </tr>
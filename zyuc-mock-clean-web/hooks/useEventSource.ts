@@ -2,13 +2,33 @@ import { useState, useEffect } from 'react';
 
 export interface SseEventData {
     requestId: string;
+    method?: string; // HTTP
     payload?: string; // HTTP
     endpoint?: string; // HTTP
     project?: string;
     source?: string;
     command?: string; // SSH
     defaultResponse: string;
+    defaultStatusCode?: number; // HTTP
+    defaultContentType?: string; // HTTP
+    defaultHeaders?: Record<string, string>; // HTTP
+    defaultStderr?: string; // SSH
+    defaultExitCode?: number; // SSH
     type: 'http' | 'ssh';
+    holdTimeout?: number; // 挂起秒数，-1 表示一直等待人工响应
+    expiresAt?: number; // 自动返回默认响应的时间（毫秒时间戳），0 表示不会自动返回
+    resolvedStatus?: string; // 请求已被处理（他人响应、释放、拒绝或超时）时的状态
+    claimedBy?: string; // 认领该请求的操作员
+}
+
+// 挂起请求状态变化时推送的消息
+interface PendingUpdate {
+    event: 'pending.resolved' | 'pending.extended' | 'pending.claimed' | 'pending.unclaimed';
+    requestId: string;
+    status?: string;
+    operator?: string;
+    holdTimeout?: number;
+    expiresAt?: number;
 }
 
 
@@ -77,19 +97,50 @@ export const useEventSource = (bootstrapUrl: string) => {
         console.log(`Connecting EventSource to primary: ${fullUrl}`);
         const eventSource = new EventSource(fullUrl);
 
+        const addEvents = (incoming: SseEventData[]) => {
+            setEvents(prev => {
+                const known = new Set(prev.map(e => e.requestId));
+                const fresh = incoming.filter(e => !known.has(e.requestId));
+                return fresh.length > 0 ? [...fresh, ...prev] : prev;
+            });
+        };
+
+        const applyUpdate = (update: PendingUpdate) => {
+            setEvents(prev => prev.map(e => {
+                if (e.requestId !== update.requestId) return e;
+                if (update.event === 'pending.extended') {
+                    return { ...e, holdTimeout: update.holdTimeout, expiresAt: update.expiresAt };
+                }
+                if (update.event === 'pending.claimed') {
+                    return { ...e, claimedBy: update.operator };
+                }
+                if (update.event === 'pending.unclaimed') {
+                    return { ...e, claimedBy: undefined };
+                }
+                return { ...e, resolvedStatus: update.status };
+            }));
+        };
+
         eventSource.onopen = () => {
             console.log(`EventSource connection established to primary ${primaryService}`);
-            // SSE connection status is a layer on top of general service status
-            // We can keep this for more detailed debugging if needed
+            // 重新连接后补齐断线期间错过的挂起请求
+            fetch(`${protocol}//${primaryService}/api/pending`)
+                .then(res => res.ok ? res.json() : { data: [] })
+                .then(({ data }) => addEvents([...(data || [])].reverse()))
+                .catch(error => console.error(`Failed to fetch pending requests from ${primaryService}:`, error));
         };
 
         eventSource.addEventListener('message', (event) => {
             try {
                 const data = JSON.parse(event.data);
+                if (data.event) {
+                    applyUpdate(data as PendingUpdate);
+                    return;
+                }
                 if (!data.type) {
                     data.type = 'http'; // Default to http if type is not specified
                 }
-                setEvents(prev => [data, ...prev]);
+                addEvents([data]);
             } catch (error) {
                 console.error(`Failed to parse SSE message from ${primaryService}:`, error);
             }
@@ -1,6 +1,8 @@
 /** @type {import('next').NextConfig} */
 const nextConfig = {
   output: 'export',
+  // 界面挂在后端的保留前缀下，其余路径全部留给 mock，需与 main.go 中的 uiPrefix 一致
+  basePath: '/_ui',
   // 保持为空，或者只保留你需要的其他非代理配置
   // distDir: 'build' // 如果你需要这个，可以保留
 };